@@ -0,0 +1,382 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+)
+
+// canEditCollection reports whether the user making the request owns the collection or is a collections admin
+func (app *application) canEditCollection(r *http.Request, collection *data.Collection) (bool, error) {
+	user := app.contextGetUser(r)
+	if user.IsAnonymous() {
+		return false, nil
+	}
+	if collection.UserID == user.ID {
+		return true, nil
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		return false, err
+	}
+	return permissions.Includes("collections:admin"), nil
+}
+
+// readCollection fetches the collection named in the url and checks the user can see it, or edit it when edit is set.
+// Private collections look like they don't exist to anyone who can't edit them
+func (app *application) readCollection(w http.ResponseWriter, r *http.Request, edit bool) (*data.Collection, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	collection, err := app.models.Collections.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	allowed, err := app.canEditCollection(r, collection)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	switch {
+	case allowed:
+		return collection, true
+	case collection.Visibility == "private":
+		app.notFoundResponse(w, r)
+	case edit:
+		app.notPermittedResponse(w, r)
+	default:
+		return collection, true
+	}
+	return nil, false
+}
+
+// listCollectionsHandler lists the public collections, with mine=true the user's own ones instead
+func (app *application) listCollectionsHandler(w http.ResponseWriter, r *http.Request) {
+
+	var filters data.Filters
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	title := app.readString(qs, "title", "")
+	mine := app.readBool(qs, "mine", false, v)
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-created_at")
+
+	filters.SortSafeList = []string{"id", "title", "created_at", "-id", "-title", "-created_at"}
+
+	data.ValidateFilters(v, &filters)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	userID := 0
+	if mine {
+		userID = app.contextGetUser(r).ID
+	}
+
+	collections, metadata, err := app.models.Collections.GetAll(r.Context(), title, userID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"collections": collections, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createCollectionHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		Visibility  string `json:"visibility"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	collection := data.Collection{
+		UserID:      app.contextGetUser(r).ID,
+		Title:       input.Title,
+		Description: input.Description,
+		Visibility:  input.Visibility,
+	}
+	if collection.Visibility == "" {
+		collection.Visibility = "private"
+	}
+
+	v := validator.New()
+
+	data.ValidateCollection(v, &collection)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Collections.Insert(r.Context(), &collection)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("v1/collections/%d", collection.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"collection": collection}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.readCollection(w, r, false)
+	if !ok {
+		return
+	}
+
+	app.writeCollection(w, r, collection, http.StatusOK)
+}
+
+func (app *application) updateCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.readCollection(w, r, true)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Title       *string `json:"title"`
+		Description *string `json:"description"`
+		Visibility  *string `json:"visibility"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Title != nil {
+		collection.Title = *input.Title
+	}
+	if input.Description != nil {
+		collection.Description = *input.Description
+	}
+	if input.Visibility != nil {
+		collection.Visibility = *input.Visibility
+	}
+
+	v := validator.New()
+
+	data.ValidateCollection(v, collection)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Collections.Update(r.Context(), collection)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"collection": collection}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.readCollection(w, r, true)
+	if !ok {
+		return
+	}
+
+	err := app.models.Collections.Delete(r.Context(), collection.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "collection successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// addCollectionMovieHandler inserts a movie at position (1 is the top), without a position it goes at the end
+func (app *application) addCollectionMovieHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.readCollection(w, r, true)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		MovieID  int `json:"movie_id"`
+		Position int `json:"position"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.MovieID > 0, "movie_id", "must be provided")
+	v.Check(input.Position >= 0, "position", "must not be negative")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !app.movieExists(w, r, input.MovieID) {
+		return
+	}
+
+	err = app.models.Collections.AddMovie(r.Context(), collection, input.MovieID, input.Position)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateCollectionMovie):
+			v.AddError("movie_id", "is already in the collection")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeCollection(w, r, collection, http.StatusOK)
+}
+
+func (app *application) removeCollectionMovieHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.readCollection(w, r, true)
+	if !ok {
+		return
+	}
+
+	movieID, err := app.readIntParam(r, "movie_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Collections.RemoveMovie(r.Context(), collection, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeCollection(w, r, collection, http.StatusOK)
+}
+
+// reorderCollectionHandler takes every movie of the collection in the new order. A version can be
+// sent to make sure the collection didn't change since the client last read it
+func (app *application) reorderCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.readCollection(w, r, true)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		MovieIDs []int `json:"movie_ids"`
+		Version  *int  `json:"version"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.MovieIDs != nil, "movie_ids", "must be provided")
+	v.Check(validator.UniqueValues(input.MovieIDs), "movie_ids", "must not contain duplicate values")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if input.Version != nil && *input.Version != collection.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	err = app.models.Collections.Reorder(r.Context(), collection, input.MovieIDs)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrCollectionMismatch):
+			v.AddError("movie_ids", "must contain every movie of the collection exactly once")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeCollection(w, r, collection, http.StatusOK)
+}
+
+// writeCollection sends the collection back together with its movies
+func (app *application) writeCollection(w http.ResponseWriter, r *http.Request, collection *data.Collection, status int) {
+	var err error
+	collection.Movies, err = app.models.Collections.GetMovies(r.Context(), collection.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, status, envelope{"collection": collection}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+)
+
+// listDuplicatesHandler lists pairs of movies that look like the same one, from the same year with similar titles
+func (app *application) listDuplicatesHandler(w http.ResponseWriter, r *http.Request) {
+
+	var filters data.Filters
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	minSimilarity := app.readFloat(qs, "min_similarity", 0.6, v)
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-similarity")
+
+	filters.SortSafeList = []string{"similarity", "year", "-similarity", "-year"}
+
+	v.Check(minSimilarity > 0 && minSimilarity <= 1, "min_similarity", "must be greater than 0 and at most 1")
+	data.ValidateFilters(v, &filters)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	candidates, metadata, err := app.models.Movies.FindDuplicates(r.Context(), minSimilarity, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"duplicates": candidates, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// mergeMovieHandler folds the movie given as duplicate_id into the one in the url. The target keeps its
+// title, year and runtime, gains the duplicate's genres and external ids (and poster if it had none),
+// and takes over its credits, reviews, watchlists, collections, localized titles and revisions. The duplicate is deleted for good
+func (app *application) mergeMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		DuplicateID int  `json:"duplicate_id"`
+		Version     *int `json:"version"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.DuplicateID > 0, "duplicate_id", "must be provided")
+	v.Check(input.DuplicateID != id, "duplicate_id", "a movie can't be merged into itself")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	target, err := app.models.Movies.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if input.Version != nil && *input.Version != target.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	duplicate, err := app.models.Movies.Get(r.Context(), input.DuplicateID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("duplicate_id", "no movie matching this id")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	for _, genre := range duplicate.Genres {
+		if !slices.Contains(target.Genres, genre) {
+			target.Genres = append(target.Genres, genre)
+		}
+	}
+
+	for source, externalID := range duplicate.ExternalIDs {
+		current, ok := target.ExternalIDs[source]
+		switch {
+		case !ok:
+			if target.ExternalIDs == nil {
+				target.ExternalIDs = make(data.ExternalIDs)
+			}
+			target.ExternalIDs[source] = externalID
+		case current != externalID:
+			v.AddError("external_ids", "the movies have different "+source+" ids")
+		}
+	}
+
+	if target.Images == nil {
+		target.Images = duplicate.Images
+	}
+
+	// e.g. the combined genres can go over the limit
+	data.ValidateMovie(v, target)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	unused, err := app.models.Movies.Merge(r.Context(), target, duplicate, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateExternalID):
+			v.AddError("external_ids", "another movie already has one of these ids")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.deleteKeys(unused)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": target}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+
+	"greenlight/internal/data"
 )
 
 func (app *application) logError(r *http.Request, err error) {
@@ -81,6 +84,12 @@ func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Requ
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
 
+// This will be used to send a 415 Unsupported Media Type
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request) {
+	message := fmt.Sprintf("the %q content type is not supported for this resource", r.Header.Get("Content-Type"))
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+}
+
 // This will be used to send a 422 Unprocessable entity
 func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
 	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
@@ -92,8 +101,21 @@ func (app *application) rateLimitExceedResponse(w http.ResponseWriter, r *http.R
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
 }
 
+// This will be used to send a 503 when a query was canceled: the client went away (and won't read it)
+// or the server is shutting down, neither is an error of ours
+func (app *application) queryCanceledResponse(w http.ResponseWriter, r *http.Request) {
+	app.logger.Info("request canceled", "method", r.Method, "uri", r.URL.RequestURI())
+	message := "the request was canceled before it could complete"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, message)
+}
+
 // This will be used to send a 505 internal server error
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, data.ErrQueryCanceled) {
+		app.queryCanceledResponse(w, r)
+		return
+	}
+
 	app.logError(r, err)
 	message := " the server encountered a problem could not process your request"
 	app.errorResponse(w, r, http.StatusInternalServerError, message)
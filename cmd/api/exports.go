@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+)
+
+// movieEncoder writes exported movies one at a time, begin is called before the first movie and end after the last
+type movieEncoder interface {
+	begin() error
+	encode(movie *data.Movie) error
+	end() error
+}
+
+// exportMoviesHandler dumps every movie matching the same filters as listMoviesHandler (without paging)
+// as csv, ndjson or json. Rows are written as they come out of the database cursor, the body is never
+// buffered whole like writeJSON does
+func (app *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input, err := app.readMovieQuery(r.Context(), qs, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	format := app.readString(qs, "format", "ndjson")
+	runtimeFormat := app.readRuntimeFormat(qs, v)
+
+	v.Check(validator.PermittedValue(format, "csv", "ndjson", "json"), "format", "must be csv, ndjson or json")
+	data.ValidateSort(v, &input.Filters)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var enc movieEncoder
+	var contentType string
+
+	switch format {
+	case "csv":
+		enc = newCSVMovieEncoder(w, input.Fields)
+		contentType = "text/csv; charset=utf-8"
+	case "json":
+		enc = &jsonMovieEncoder{w: w, fields: input.Fields}
+		contentType = "application/json"
+	default:
+		enc = &ndjsonMovieEncoder{enc: json.NewEncoder(w), fields: input.Fields}
+		contentType = "application/x-ndjson"
+	}
+
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(app.config.exports.timeout)
+	_ = rc.SetWriteDeadline(deadline)
+
+	ctx, cancel := context.WithDeadline(r.Context(), deadline)
+	defer cancel()
+
+	// until the first byte is written we can still answer with a proper error response
+	started := false
+	start := func() error {
+		if started {
+			return nil
+		}
+		started = true
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="movies.%s"`, format))
+		w.WriteHeader(http.StatusOK)
+		return enc.begin()
+	}
+
+	err = app.models.Movies.Export(ctx, input, func(movie *data.Movie) error {
+		err := start()
+		if err != nil {
+			return err
+		}
+		movie.RuntimeFormat = runtimeFormat
+		return enc.encode(movie)
+	})
+	if err == nil {
+		err = start()
+	}
+	if err == nil {
+		err = enc.end()
+	}
+
+	if err != nil {
+		if !started {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		// the status is already out, all we can do is log it and cut the body short
+		app.logError(r, err)
+	}
+}
+
+// exportBody is what a single movie looks like in json and ndjson exports
+func exportBody(movie *data.Movie, fields []string) any {
+	if len(fields) > 0 {
+		return movie.Project(fields)
+	}
+	return movie
+}
+
+type ndjsonMovieEncoder struct {
+	enc    *json.Encoder
+	fields []string
+}
+
+func (e *ndjsonMovieEncoder) begin() error { return nil }
+
+func (e *ndjsonMovieEncoder) encode(movie *data.Movie) error {
+	// Encode already ends every value with a newline
+	return e.enc.Encode(exportBody(movie, e.fields))
+}
+
+func (e *ndjsonMovieEncoder) end() error { return nil }
+
+// jsonMovieEncoder writes {"movies": [...]} element by element
+type jsonMovieEncoder struct {
+	w      io.Writer
+	fields []string
+	count  int
+}
+
+func (e *jsonMovieEncoder) begin() error {
+	_, err := io.WriteString(e.w, `{"movies":[`)
+	return err
+}
+
+func (e *jsonMovieEncoder) encode(movie *data.Movie) error {
+	js, err := json.Marshal(exportBody(movie, e.fields))
+	if err != nil {
+		return err
+	}
+
+	if e.count > 0 {
+		js = append([]byte(",\n"), js...)
+	} else {
+		js = append([]byte("\n"), js...)
+	}
+	e.count++
+
+	_, err = e.w.Write(js)
+	return err
+}
+
+func (e *jsonMovieEncoder) end() error {
+	_, err := io.WriteString(e.w, "\n]}\n")
+	return err
+}
+
+// csvMovieEncoder writes runtime and genres the same way the CSV import reads them
+type csvMovieEncoder struct {
+	w      *csv.Writer
+	fields []string
+}
+
+func newCSVMovieEncoder(w io.Writer, fields []string) *csvMovieEncoder {
+	if len(fields) == 0 {
+		fields = data.MovieFieldSafeList
+	}
+	return &csvMovieEncoder{w: csv.NewWriter(w), fields: fields}
+}
+
+func (e *csvMovieEncoder) begin() error {
+	return e.w.Write(e.fields)
+}
+
+func (e *csvMovieEncoder) encode(movie *data.Movie) error {
+	record := make([]string, len(e.fields))
+
+	for i, field := range e.fields {
+		switch field {
+		case "id":
+			record[i] = strconv.Itoa(movie.ID)
+		case "title":
+			record[i] = movie.Title
+		case "year":
+			record[i] = strconv.Itoa(movie.Year)
+		case "runtime":
+			record[i] = movie.Runtime.Format(movie.RuntimeFormat)
+		case "genres":
+			record[i] = strings.Join(movie.Genres, "|")
+		case "rating":
+			record[i] = strconv.FormatFloat(movie.Rating, 'f', -1, 64)
+		case "votes":
+			record[i] = strconv.Itoa(movie.Votes)
+		case "images":
+			if movie.Images != nil {
+				record[i] = movie.Images.Poster.URL
+			}
+		case "external_ids":
+			ids := make([]string, 0, len(movie.ExternalIDs))
+			for source, id := range movie.ExternalIDs {
+				ids = append(ids, source+":"+id)
+			}
+			slices.Sort(ids)
+			record[i] = strings.Join(ids, "|")
+		case "version":
+			record[i] = strconv.Itoa(movie.Version)
+		}
+	}
+
+	return e.w.Write(record)
+}
+
+func (e *csvMovieEncoder) end() error {
+	e.w.Flush()
+	return e.w.Error()
+}
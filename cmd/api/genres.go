@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+)
+
+func (app *application) listGenresHandler(w http.ResponseWriter, r *http.Request) {
+	genres, err := app.models.Genres.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"genres": genres}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// normalizeGenres swaps the genres of a movie for their canonical slugs, unknown ones are reported in v.
+// It has to run before ValidateMovie so "Sci-Fi" and "Science Fiction" count as the same genre
+func (app *application) normalizeGenres(ctx context.Context, v *validator.Validator, movie *data.Movie) error {
+	resolver, err := app.models.Genres.Resolver(ctx)
+	if err != nil {
+		return err
+	}
+
+	movie.Genres = resolver.Normalize(v, movie.Genres)
+	return nil
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,6 +12,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 )
@@ -54,6 +57,38 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// readFloat read a float from a querystring, records potential errors in a validator
+func (app *application) readFloat(qs url.Values, key string, defaultValue float64, v *validator.Validator) float64 {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		v.AddError(key, "key must be a number")
+		return defaultValue
+	}
+
+	return f
+}
+
+// readBool read a bool from a querystring, records potential errors in a validator
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "key must be a boolean")
+		return defaultValue
+	}
+
+	return b
+}
+
 // readCSV reads a csv from a query string and splits it over a comma
 func (app *application) readCSV(qs url.Values, key string, defaultValue []string) []string {
 	csv := qs.Get(key)
@@ -66,16 +101,21 @@ func (app *application) readCSV(qs url.Values, key string, defaultValue []string
 }
 
 func (app *application) readIDParam(r *http.Request) (int, error) {
+	return app.readIntParam(r, "id")
+}
+
+// readIntParam reads a positive integer from a named url parameter, e.g. :version
+func (app *application) readIntParam(r *http.Request, name string) (int, error) {
 
 	params := httprouter.ParamsFromContext(r.Context())
 
-	id, err := strconv.Atoi(params.ByName("id"))
+	i, err := strconv.Atoi(params.ByName(name))
 
-	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+	if err != nil || i < 1 {
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 
-	return id, nil
+	return i, nil
 }
 
 type envelope map[string]any
@@ -102,6 +142,37 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data envelo
 	return nil
 }
 
+// writeCachedJSON writes a 200 clients may keep for maxAge. The ETag is a hash of the body, so a client
+// revalidating with If-None-Match gets an empty 304 as long as nothing changed
+func (app *application) writeCachedJSON(w http.ResponseWriter, r *http.Request, data envelope, maxAge time.Duration) error {
+	js, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	js = append(js, '\n')
+
+	sum := sha256.Sum256(js)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	// private since every response depends on who is asking, shared caches must not keep it
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(maxAge.Seconds())))
+	w.Header().Set("ETag", etag)
+
+	for _, match := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		match = strings.TrimPrefix(strings.TrimSpace(match), "W/")
+		if match == etag || match == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(js)
+	return nil
+}
+
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, destination any) error {
 
 	// bytes 1 MB
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"greenlight/internal/data"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// posterTypes are the image formats accepted for posters, by sniffed content type, with their file extension
+var posterTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+// posterThumbnails are the widths thumbnails are generated at, heights keep the poster's aspect ratio
+var posterThumbnails = map[string]int{
+	"small":  185,
+	"medium": 342,
+}
+
+// posterMaxPixels keeps a small file that decodes to a huge image from eating all the memory
+const posterMaxPixels = 40_000_000
+
+// uploadPosterHandler takes a multipart/form-data body with the image in a "poster" field. The original is
+// stored as it was sent and JPEG thumbnails are generated from it, an existing poster is replaced
+func (app *application) uploadPosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if !app.movieExists(w, r, id) {
+		return
+	}
+
+	// a little room on top of the image for the multipart boundaries and headers
+	r.Body = http.MaxBytesReader(w, r.Body, app.config.images.maxBytes+64<<10)
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		app.unsupportedMediaTypeResponse(w, r)
+		return
+	}
+
+	poster, err := app.readPoster(reader)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.badRequestResponse(w, r, fmt.Errorf("poster must not be larger than %d bytes", app.config.images.maxBytes))
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	images, err := app.storePoster(r.Context(), id, poster)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	previous, err := app.models.Movies.SetImages(r.Context(), id, images)
+	if err != nil {
+		app.deleteImages(images)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.deleteImages(previous)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"images": images}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deletePosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	previous, err := app.models.Movies.SetImages(r.Context(), id, nil)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if previous == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	app.deleteImages(previous)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "poster successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// upload is a poster read from the request, with the content type sniffed from its bytes
+type upload struct {
+	body        []byte
+	contentType string
+}
+
+// readPoster finds the "poster" part and checks it really is an image we can handle,
+// whatever content type the client claimed
+func (app *application) readPoster(reader *multipart.Reader) (*upload, error) {
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("body must contain a poster field")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if part.FormName() != "poster" {
+			part.Close()
+			continue
+		}
+
+		body, err := io.ReadAll(io.LimitReader(part, app.config.images.maxBytes+1))
+		part.Close()
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(body)) > app.config.images.maxBytes {
+			return nil, fmt.Errorf("poster must not be larger than %d bytes", app.config.images.maxBytes)
+		}
+
+		contentType := http.DetectContentType(body)
+		_, ok := posterTypes[contentType]
+		if !ok {
+			return nil, errors.New("poster must be a JPEG, PNG or WebP image")
+		}
+
+		dimensions, _, err := image.DecodeConfig(bytes.NewReader(body))
+		if err != nil {
+			return nil, errors.New("poster is not a valid image")
+		}
+		if dimensions.Width*dimensions.Height > posterMaxPixels {
+			return nil, fmt.Errorf("poster must not have more than %d pixels", posterMaxPixels)
+		}
+
+		return &upload{body: body, contentType: contentType}, nil
+	}
+}
+
+// storePoster saves the original and its thumbnails under names that change on every upload,
+// so they can be cached forever and the previous poster stays served until the movie points to the new one
+func (app *application) storePoster(ctx context.Context, movieID int, poster *upload) (*data.MovieImages, error) {
+	src, _, err := image.Decode(bytes.NewReader(poster.body))
+	if err != nil {
+		return nil, err
+	}
+
+	token := make([]byte, 8)
+	_, err = rand.Read(token)
+	if err != nil {
+		return nil, err
+	}
+	prefix := fmt.Sprintf("movies/%d/%s", movieID, hex.EncodeToString(token))
+
+	images := &data.MovieImages{Thumbnails: make(map[string]data.Image, len(posterThumbnails))}
+
+	stored := []string{}
+	put := func(key string, body []byte, contentType string) (string, error) {
+		location, err := app.storage.Put(ctx, key, bytes.NewReader(body), contentType)
+		if err != nil {
+			return "", err
+		}
+		stored = append(stored, key)
+		return location, nil
+	}
+
+	images.Poster = data.Image{
+		Key:    prefix + "-poster" + posterTypes[poster.contentType],
+		Width:  src.Bounds().Dx(),
+		Height: src.Bounds().Dy(),
+	}
+	images.Poster.URL, err = put(images.Poster.Key, poster.body, poster.contentType)
+
+	for size, width := range posterThumbnails {
+		if err != nil {
+			break
+		}
+
+		var thumbnail []byte
+		var bounds image.Rectangle
+		thumbnail, bounds, err = resizeJPEG(src, width)
+		if err != nil {
+			break
+		}
+
+		thumb := data.Image{
+			Key:    fmt.Sprintf("%s-%s.jpg", prefix, size),
+			Width:  bounds.Dx(),
+			Height: bounds.Dy(),
+		}
+		thumb.URL, err = put(thumb.Key, thumbnail, "image/jpeg")
+		images.Thumbnails[size] = thumb
+	}
+
+	// don't leave half a poster behind
+	if err != nil {
+		app.deleteKeys(stored)
+		return nil, err
+	}
+
+	return images, nil
+}
+
+// resizeJPEG scales the image down to width, keeping its aspect ratio. Images narrower than width are left as they are
+func resizeJPEG(src image.Image, width int) ([]byte, image.Rectangle, error) {
+	bounds := src.Bounds()
+	if bounds.Dx() > width {
+		height := max(1, bounds.Dy()*width/bounds.Dx())
+		bounds = image.Rect(0, 0, width, height)
+	} else {
+		bounds = image.Rect(0, 0, bounds.Dx(), bounds.Dy())
+	}
+
+	dst := image.NewRGBA(bounds)
+	draw.CatmullRom.Scale(dst, bounds, src, src.Bounds(), draw.Src, nil)
+
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85})
+	if err != nil {
+		return nil, image.Rectangle{}, err
+	}
+
+	return buf.Bytes(), bounds, nil
+}
+
+// deleteImages removes the files of images that are no longer used, in the background since the client
+// doesn't need to wait for it and a failure only leaves an orphaned file behind
+func (app *application) deleteImages(images *data.MovieImages) {
+	app.deleteKeys(images.Keys())
+}
+
+func (app *application) deleteKeys(keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+
+	app.background(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		for _, key := range keys {
+			err := app.storage.Delete(ctx, key)
+			if err != nil {
+				app.logger.Error("failed to delete stored image", "key", key, "error", err.Error())
+			}
+		}
+	})
+}
+
+// storagePath is the path part of the storage url, where the local backend serves its files from
+func (app *application) storagePath() string {
+	u, err := url.Parse(app.config.storage.url)
+	if err != nil {
+		return "/images"
+	}
+	return "/" + strings.Trim(u.Path, "/")
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+)
+
+// importResult is the outcome of a single row, rows are numbered from 1 not counting the CSV header
+type importResult struct {
+	Row    int               `json:"row"`
+	ID     int               `json:"id,omitzero"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// importRowFunc is called by the parsers once per row, with either a movie or the reason it couldn't be read
+type importRowFunc func(movie *data.Movie, errs map[string]string) error
+
+// importMoviesHandler creates movies in bulk from a CSV (text/csv) or NDJSON (application/x-ndjson) body.
+// Every row has its genres normalized and goes through ValidateMovie, the valid ones are inserted and the invalid ones reported back,
+// like the ones with an external id another movie already has.
+// With dry_run=true the rows are only validated and nothing is written
+func (app *application) importMoviesHandler(w http.ResponseWriter, r *http.Request) {
+
+	v := validator.New()
+	dryRun := app.readBool(r.URL.Query(), "dry_run", false, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		app.unsupportedMediaTypeResponse(w, r)
+		return
+	}
+
+	var parse func(io.Reader, importRowFunc) error
+	switch mediaType {
+	case "text/csv":
+		parse = readCSVMovies
+	case "application/x-ndjson", "application/ndjson":
+		parse = readNDJSONMovies
+	default:
+		app.unsupportedMediaTypeResponse(w, r)
+		return
+	}
+
+	// the body is streamed, so it isn't bound by readJSON's 1MB cap but by its own limit,
+	// and the server read/write timeouts are pushed back to give big catalogs time to upload
+	r.Body = http.MaxBytesReader(w, r.Body, app.config.imports.maxBytes)
+
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(app.config.imports.timeout)
+	_ = rc.SetReadDeadline(deadline)
+	_ = rc.SetWriteDeadline(deadline)
+
+	// the transaction gets the same deadline, none of the query timeouts apply to it
+	ctx, cancel := context.WithDeadline(r.Context(), deadline)
+	defer cancel()
+
+	// loaded once, every row is normalized against the same taxonomy
+	resolver, err := app.models.Genres.Resolver(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var movieImport data.MovieImporter
+	if !dryRun {
+		movieImport, err = app.models.Movies.NewImport(ctx)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		defer movieImport.Rollback()
+	}
+
+	results := []*importResult{}
+	batch := []*data.Movie{}
+	batchResults := []*importResult{}
+	failed := 0
+
+	flush := func() error {
+		if movieImport != nil {
+			taken, err := movieImport.InsertBatch(batch, app.contextGetUser(r).ID)
+			if err != nil {
+				return err
+			}
+			for i, movie := range batch {
+				if slices.Contains(taken, i) {
+					batchResults[i].Errors = map[string]string{"external_ids": "another movie already has one of these ids"}
+					failed++
+					continue
+				}
+				batchResults[i].ID = movie.ID
+			}
+		}
+		batch = batch[:0]
+		batchResults = batchResults[:0]
+		return nil
+	}
+
+	err = parse(r.Body, func(movie *data.Movie, errs map[string]string) error {
+		result := &importResult{Row: len(results) + 1}
+		results = append(results, result)
+
+		if errs == nil {
+			v := validator.New()
+			movie.Genres = resolver.Normalize(v, movie.Genres)
+			data.ValidateMovie(v, movie)
+			errs = v.Errors
+		}
+
+		if len(errs) > 0 {
+			result.Errors = errs
+			failed++
+			return nil
+		}
+
+		batch = append(batch, movie)
+		batchResults = append(batchResults, result)
+		if len(batch) == data.ImportBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		var importError *importFormatError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.badRequestResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit))
+		case errors.As(err, &importError):
+			app.badRequestResponse(w, r, err)
+		case errors.Is(err, data.ErrDuplicateExternalID):
+			// a movie with one of the ids was created while importing, running it again reports the row
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if movieImport != nil {
+		err = movieImport.Commit()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	report := envelope{
+		"dry_run":   dryRun,
+		"total":     len(results),
+		"succeeded": len(results) - failed,
+		"failed":    failed,
+		"rows":      results,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"import": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// importFormatError is returned when the file as a whole can't be read (e.g. a bad CSV header),
+// as opposed to a single bad row which is only reported for that row
+type importFormatError struct {
+	message string
+}
+
+func (e *importFormatError) Error() string {
+	return e.message
+}
+
+// importColumns are the CSV header names, genres are separated by | inside their cell. external_ids is
+// optional, it holds source:id pairs separated by | like the CSV export writes them
+var (
+	importColumns         = []string{"title", "year", "runtime", "genres"}
+	optionalImportColumns = []string{"external_ids"}
+)
+
+// readCSVMovies reads a CSV with a header row, columns can be in any order
+func readCSVMovies(body io.Reader, fn importRowFunc) error {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return &importFormatError{"body must not be empty"}
+		}
+		return err
+	}
+
+	index := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !validator.PermittedValue(name, importColumns...) && !validator.PermittedValue(name, optionalImportColumns...) {
+			return &importFormatError{fmt.Sprintf("unknown CSV column %q", name)}
+		}
+		index[name] = i
+	}
+	for _, name := range importColumns {
+		_, ok := index[name]
+		if !ok {
+			return &importFormatError{fmt.Sprintf("missing CSV column %q", name)}
+		}
+	}
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		var parseError *csv.ParseError
+		switch {
+		case errors.As(err, &parseError):
+			err = fn(nil, map[string]string{"row": parseError.Err.Error()})
+		case err != nil:
+			return err
+		case len(record) != len(header):
+			err = fn(nil, map[string]string{"row": "wrong number of fields"})
+		default:
+			err = fn(parseCSVMovie(record, index))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// parseCSVMovie converts a record into a movie, keyed errors match the ones ValidateMovie uses
+func parseCSVMovie(record []string, index map[string]int) (*data.Movie, map[string]string) {
+	v := validator.New()
+
+	movie := &data.Movie{
+		Title: strings.TrimSpace(record[index["title"]]),
+	}
+
+	year, err := strconv.Atoi(strings.TrimSpace(record[index["year"]]))
+	v.Check(err == nil, "year", "must be an integer")
+	movie.Year = year
+
+	runtime, err := data.ParseRuntime(strings.TrimSpace(record[index["runtime"]]))
+	v.Check(err == nil, "runtime", "invalid runtime format")
+	movie.Runtime = runtime
+
+	movie.Genres = []string{}
+	for genre := range strings.SplitSeq(record[index["genres"]], "|") {
+		genre = strings.TrimSpace(genre)
+		if genre != "" {
+			movie.Genres = append(movie.Genres, genre)
+		}
+	}
+
+	if i, ok := index["external_ids"]; ok {
+		movie.ExternalIDs = parseCSVExternalIDs(v, record[i])
+	}
+
+	if !v.Valid() {
+		return nil, v.Errors
+	}
+	return movie, nil
+}
+
+// parseCSVExternalIDs reads "imdb:tt0133093|tmdb:603", the sources and ids themselves are checked by ValidateMovie
+func parseCSVExternalIDs(v *validator.Validator, cell string) data.ExternalIDs {
+	var ids data.ExternalIDs
+	for pair := range strings.SplitSeq(cell, "|") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		source, id, ok := strings.Cut(pair, ":")
+		if !ok {
+			v.AddError("external_ids", "must be source:id pairs separated by |")
+			return nil
+		}
+		if _, ok := ids[source]; ok {
+			v.AddError("external_ids", "duplicate source "+source)
+			return nil
+		}
+
+		if ids == nil {
+			ids = make(data.ExternalIDs)
+		}
+		ids[source] = id
+	}
+	return ids
+}
+
+// readNDJSONMovies reads one JSON movie per line, a broken line only fails that row
+func readNDJSONMovies(body io.Reader, fn importRowFunc) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1_048_576)
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var input struct {
+			Title   string       `json:"title"`
+			Year    int          `json:"year"`
+			Runtime data.Runtime `json:"runtime"`
+			Genres  []string     `json:"genres"`
+
+			ExternalIDs map[string]string `json:"external_ids"`
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.DisallowUnknownFields()
+
+		var err error
+		if decodeErr := dec.Decode(&input); decodeErr != nil {
+			err = fn(nil, map[string]string{"row": decodeErr.Error()})
+		} else {
+			err = fn(&data.Movie{
+				Title:   input.Title,
+				Year:    input.Year,
+				Runtime: input.Runtime,
+				Genres:  input.Genres,
+
+				ExternalIDs: input.ExternalIDs,
+			}, nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if errors.Is(scanner.Err(), bufio.ErrTooLong) {
+		return &importFormatError{"lines must not be longer than 1048576 bytes"}
+	}
+	return scanner.Err()
+}
@@ -4,13 +4,18 @@ import (
 	"context"
 	"database/sql"
 	"flag"
+	"fmt"
 	"log/slog"
+	"net/url"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
 	"greenlight/internal/data"   //Postgrees go driver
 	"greenlight/internal/mailer" //Postgrees go driver
+	"greenlight/internal/storage"
+	"greenlight/internal/validator"
 
 	_ "github.com/lib/pq"
 )
@@ -26,12 +31,72 @@ type config struct {
 		maxOpenConns int
 		maxIdleConns int
 		maxIdleTime  time.Duration
+
+		// how long each kind of query can run, requests can still cancel them sooner
+		timeouts data.Timeouts
 	}
 
 	limiter struct {
 		rps    float64
 		burst  int
 		enable bool
+
+		// autocomplete fires on every keystroke so it has its own, more generous, limits
+		suggestRps   float64
+		suggestBurst int
+	}
+
+	// bulk imports stream their body, so they have their own size and time limits
+	imports struct {
+		maxBytes int64
+		timeout  time.Duration
+	}
+
+	exports struct {
+		timeout time.Duration
+	}
+
+	// deleted movies stay restorable for retention, the purge job checks once every purgeInterval
+	trash struct {
+		retention     time.Duration
+		purgeInterval time.Duration
+	}
+
+	// tags wrapped around matched words when a listing asks for highlight=true
+	highlight data.Highlight
+
+	// where uploaded images are kept, url is the public address the files are served from
+	storage struct {
+		backend string
+		dir     string
+		url     string
+	}
+
+	// similar movies are cached by clients for maxAge, they hardly change between two visits
+	similar struct {
+		maxAge time.Duration
+	}
+
+	// poster uploads have their own size limit, they never go through readJSON
+	images struct {
+		maxBytes int64
+	}
+
+	// emails are queued in the outbox and sent by a worker that polls it every pollInterval, batchSize
+	// at a time. A claimed email is leased for lease, failed ones are retried following retry
+	outbox struct {
+		pollInterval time.Duration
+		batchSize    int
+		lease        time.Duration
+		retry        data.OutboxRetry
+	}
+
+	// backend is smtp, file (.eml files in dir) or log, only smtp actually sends anything. The memory
+	// mailer is only for tests, the worker marks its emails sent without anyone getting them
+	mailer struct {
+		backend string
+		sender  string
+		dir     string
 	}
 
 	smtp struct {
@@ -39,16 +104,19 @@ type config struct {
 		port     int
 		username string
 		password string
-		sender   string
 	}
 }
 
 type application struct {
-	config config
-	logger *slog.Logger
-	models data.Models
-	mailer *mailer.Mailer
-	wg     sync.WaitGroup
+	config  config
+	logger  *slog.Logger
+	models  data.Models
+	mailer  mailer.Sender
+	storage storage.Storage
+	wg      sync.WaitGroup
+
+	// wakes the outbox worker up when emails are queued, so they don't wait for the next poll
+	outboxWake chan struct{}
 }
 
 func main() {
@@ -69,18 +137,61 @@ func main() {
 	flag.IntVar(&config.db.maxIdleConns, "db-max-idle-conns", 25, "Postgres max amount of connection idle")
 	flag.DurationVar(&config.db.maxIdleTime, "db-max-idle-time", 15*time.Minute, "Postgres max connection idle time")
 
+	flag.DurationVar(&config.db.timeouts.Read, "db-read-timeout", data.DefaultTimeouts.Read, "Time allowed for lookups and other small queries")
+	flag.DurationVar(&config.db.timeouts.Write, "db-write-timeout", data.DefaultTimeouts.Write, "Time allowed for inserts, updates and deletes")
+	flag.DurationVar(&config.db.timeouts.Search, "db-search-timeout", data.DefaultTimeouts.Search, "Time allowed for listings, title search and recommendations")
+	flag.DurationVar(&config.db.timeouts.Bulk, "db-bulk-timeout", data.DefaultTimeouts.Bulk, "Time allowed for merges and the trash purge")
+
+	flag.StringVar(&config.mailer.backend, "mailer-backend", "smtp", "How emails are sent (smtp|file|log), only smtp delivers them")
+	flag.StringVar(&config.mailer.sender, "mailer-sender", "Magic Elves <ola@example.com>", "Sender of the emails")
+	flag.StringVar(&config.mailer.dir, "mailer-dir", "./mail", "Directory the file mailer writes .eml files to")
+
 	flag.StringVar(&config.smtp.host, "smtp-host", "sandbox.smtp.mailtrap.io", "SMTP host")
 	flag.IntVar(&config.smtp.port, "smtp-port", 2525, "SMTP posrt")
-	flag.StringVar(&config.smtp.username, "smtp-username", "b2d6588c9ee528", "SMTP username")
-	flag.StringVar(&config.smtp.password, "smtp-password", "1ce6d8c9fdee78", "SMTP password")
-	flag.StringVar(&config.smtp.sender, "smtp-sender", "Magic Elves <ola@example.com>", "SMTP sender")
+	flag.StringVar(&config.smtp.username, "smtp-username", os.Getenv("GREENLIGHT_SMTP_USERNAME"), "SMTP username (no authentication when empty)")
+	flag.StringVar(&config.smtp.password, "smtp-password", os.Getenv("GREENLIGHT_SMTP_PASSWORD"), "SMTP password")
+
+	flag.DurationVar(&config.outbox.pollInterval, "outbox-poll-interval", 5*time.Second, "How often the email outbox is checked for emails to send")
+	flag.IntVar(&config.outbox.batchSize, "outbox-batch-size", 10, "How many emails the outbox worker sends at a time")
+	flag.DurationVar(&config.outbox.lease, "outbox-lease", time.Minute, "How long a claimed email is kept from other workers while it's sent")
+	flag.IntVar(&config.outbox.retry.MaxAttempts, "outbox-max-attempts", 8, "Attempts made to send an email before it's dead-lettered")
+	flag.DurationVar(&config.outbox.retry.Backoff, "outbox-backoff", 30*time.Second, "Wait before retrying a failed email, doubled after every attempt")
+	flag.DurationVar(&config.outbox.retry.MaxBackoff, "outbox-max-backoff", time.Hour, "Longest wait between two attempts to send an email")
 
 	flag.IntVar(&config.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
 	flag.BoolVar(&config.limiter.enable, "limiter-enable", true, "Enable rate limiter")
 	flag.Float64Var(&config.limiter.rps, "limiter-rps", 2, "rate limiter requests per second")
+	flag.Float64Var(&config.limiter.suggestRps, "limiter-suggest-rps", 10, "Rate limiter requests per second for title suggestions")
+	flag.IntVar(&config.limiter.suggestBurst, "limiter-suggest-burst", 20, "Rate limiter maximum burst for title suggestions")
+
+	flag.Int64Var(&config.imports.maxBytes, "import-max-bytes", 64<<20, "Maximum size of a bulk movie import body")
+	flag.DurationVar(&config.imports.timeout, "import-timeout", 2*time.Minute, "Time allowed to upload and process a bulk movie import")
+
+	flag.DurationVar(&config.exports.timeout, "export-timeout", 10*time.Minute, "Time allowed to stream a movie export")
+
+	flag.DurationVar(&config.trash.retention, "trash-retention", 30*24*time.Hour, "How long deleted movies can be restored before being purged (0 keeps them forever)")
+	flag.DurationVar(&config.trash.purgeInterval, "trash-purge-interval", time.Hour, "How often the trash is purged")
+
+	flag.StringVar(&config.highlight.StartSel, "highlight-start", "<mark>", "Tag inserted before highlighted search matches")
+	flag.StringVar(&config.highlight.StopSel, "highlight-stop", "</mark>", "Tag inserted after highlighted search matches")
+
+	flag.StringVar(&config.storage.backend, "storage-backend", "local", "Where uploaded images are stored (local)")
+	flag.StringVar(&config.storage.dir, "storage-dir", "./uploads", "Directory uploaded images are kept in by the local storage")
+	flag.StringVar(&config.storage.url, "storage-url", "/images", "Base URL uploaded images are served from")
+
+	flag.Int64Var(&config.images.maxBytes, "image-max-bytes", 10<<20, "Maximum size of an uploaded poster")
+
+	flag.DurationVar(&config.similar.maxAge, "similar-max-age", 5*time.Minute, "How long clients may cache similar movies")
 
 	flag.Parse()
 
+	v := validator.New()
+	validateConfig(v, config)
+	if !v.Valid() {
+		logger.Error("invalid configuration", "errors", v.Errors)
+		os.Exit(1)
+	}
+
 	db, err := openDB(config)
 	if err != nil {
 		logger.Error(err.Error())
@@ -89,17 +200,50 @@ func main() {
 	defer db.Close()
 	logger.Info("database conection established")
 
-	mailer, err := mailer.New(config.smtp.host, config.smtp.port, config.smtp.username, config.smtp.password, config.smtp.sender)
+	var sender mailer.Sender
+	switch config.mailer.backend {
+	case "smtp":
+		sender, err = mailer.NewSMTP(config.smtp.host, config.smtp.port, config.smtp.username, config.smtp.password, config.mailer.sender)
+	case "file":
+		sender, err = mailer.NewFile(config.mailer.dir, config.mailer.sender)
+	case "log":
+		sender = mailer.NewLog(logger, config.mailer.sender)
+	default:
+		err = fmt.Errorf("unknown mailer backend %q", config.mailer.backend)
+	}
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	if config.mailer.backend != "smtp" {
+		logger.Warn("emails are not delivered, tokens in them are written in the clear", "mailer-backend", config.mailer.backend)
+	}
+
+	storageURL, err := url.Parse(config.storage.url)
+	if err != nil || strings.Trim(storageURL.Path, "/") == "" {
+		logger.Error("storage url must have a path other than /", "url", config.storage.url)
+		os.Exit(1)
+	}
+
+	var store storage.Storage
+	switch config.storage.backend {
+	case "local":
+		store, err = storage.NewLocal(config.storage.dir, config.storage.url)
+	default:
+		err = fmt.Errorf("unknown storage backend %q", config.storage.backend)
+	}
 	if err != nil {
 		logger.Error(err.Error())
 		os.Exit(1)
 	}
 
 	app := &application{
-		config: config,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer,
+		config:     config,
+		logger:     logger,
+		models:     data.NewModels(db, config.db.timeouts),
+		mailer:     sender,
+		storage:    store,
+		outboxWake: make(chan struct{}, 1),
 	}
 
 	err = app.serve()
@@ -110,6 +254,24 @@ func main() {
 
 }
 
+// validateConfig checks the flags that would otherwise only fail once the server is running, errors are
+// keyed by flag name
+func validateConfig(v *validator.Validator, config config) {
+	data.ValidateHighlight(v, config.highlight)
+
+	v.Check(validator.PermittedValue(config.mailer.backend, "smtp", "file", "log"), "mailer-backend", "must be smtp, file or log")
+
+	v.Check(config.trash.retention >= 0, "trash-retention", "must not be negative")
+	v.Check(config.trash.purgeInterval > 0, "trash-purge-interval", "must be greater than zero")
+
+	v.Check(config.outbox.pollInterval > 0, "outbox-poll-interval", "must be greater than zero")
+	v.Check(config.outbox.batchSize > 0, "outbox-batch-size", "must be greater than zero")
+	v.Check(config.outbox.lease > 0, "outbox-lease", "must be greater than zero")
+	v.Check(config.outbox.retry.MaxAttempts >= 1, "outbox-max-attempts", "must be at least 1")
+	v.Check(config.outbox.retry.Backoff > 0, "outbox-backoff", "must be greater than zero")
+	v.Check(config.outbox.retry.Backoff <= config.outbox.retry.MaxBackoff, "outbox-backoff", "must not be more than outbox-max-backoff")
+}
+
 func openDB(config config) (*sql.DB, error) {
 
 	db, err := sql.Open("postgres", config.db.dsn)
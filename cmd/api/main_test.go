@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+)
+
+// validConfig is a config with the values of the flag defaults that validateConfig looks at
+func validConfig() config {
+	var cfg config
+	cfg.mailer.backend = "smtp"
+	cfg.highlight = data.Highlight{StartSel: "<mark>", StopSel: "</mark>"}
+	cfg.trash.retention = 30 * 24 * time.Hour
+	cfg.trash.purgeInterval = time.Hour
+	cfg.outbox.pollInterval = 5 * time.Second
+	cfg.outbox.batchSize = 10
+	cfg.outbox.lease = time.Minute
+	cfg.outbox.retry = data.OutboxRetry{MaxAttempts: 8, Backoff: 30 * time.Second, MaxBackoff: time.Hour}
+	return cfg
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(cfg *config)
+		key    string
+	}{
+		{"defaults", func(cfg *config) {}, ""},
+		{"file mailer", func(cfg *config) { cfg.mailer.backend = "file" }, ""},
+		{"memory mailer", func(cfg *config) { cfg.mailer.backend = "memory" }, "mailer-backend"},
+		{"no mailer", func(cfg *config) { cfg.mailer.backend = "" }, "mailer-backend"},
+		{"trash kept forever", func(cfg *config) { cfg.trash.retention = 0 }, ""},
+		{"negative trash retention", func(cfg *config) { cfg.trash.retention = -time.Hour }, "trash-retention"},
+		{"no purge interval", func(cfg *config) { cfg.trash.purgeInterval = 0 }, "trash-purge-interval"},
+		{"no outbox poll interval", func(cfg *config) { cfg.outbox.pollInterval = 0 }, "outbox-poll-interval"},
+		{"no outbox batch", func(cfg *config) { cfg.outbox.batchSize = 0 }, "outbox-batch-size"},
+		{"negative outbox lease", func(cfg *config) { cfg.outbox.lease = -time.Minute }, "outbox-lease"},
+		{"no outbox attempts", func(cfg *config) { cfg.outbox.retry.MaxAttempts = 0 }, "outbox-max-attempts"},
+		{"a single outbox attempt", func(cfg *config) { cfg.outbox.retry.MaxAttempts = 1 }, ""},
+		{"no outbox backoff", func(cfg *config) { cfg.outbox.retry.Backoff = 0 }, "outbox-backoff"},
+		{"outbox backoff over the max", func(cfg *config) { cfg.outbox.retry.MaxBackoff = time.Second }, "outbox-backoff"},
+		{"outbox backoff equal to the max", func(cfg *config) { cfg.outbox.retry.MaxBackoff = cfg.outbox.retry.Backoff }, ""},
+		{"highlight tag with a quote", func(cfg *config) { cfg.highlight.StartSel = `<b class="x">` }, "highlight"},
+	}
+
+	for _, tt := range tests {
+		cfg := validConfig()
+		tt.change(&cfg)
+
+		v := validator.New()
+		validateConfig(v, cfg)
+
+		switch {
+		case tt.key == "" && !v.Valid():
+			t.Errorf("%s: got errors %v, want none", tt.name, v.Errors)
+		case tt.key != "" && v.Errors[tt.key] == "":
+			t.Errorf("%s: got errors %v, want one for %s", tt.name, v.Errors, tt.key)
+		}
+	}
+}
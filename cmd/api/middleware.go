@@ -19,7 +19,7 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 
 		user := app.contextGetUser(r)
 
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
 
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
@@ -67,7 +67,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		user, err := app.models.Users.GetForToken(r.Context(), data.ScopeAuthentication, token)
 
 		if err != nil {
 			switch {
@@ -134,7 +134,8 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
-func (app *application) rateLimit(next http.Handler) http.Handler {
+// rateLimit allows each client ip rps requests per second with bursts of up to burst requests
+func (app *application) rateLimit(rps float64, burst int, next http.Handler) http.Handler {
 	if !app.config.limiter.enable {
 		return next
 	}
@@ -172,8 +173,7 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 
 		_, found := clients[ip]
 		if !found {
-			// 2 request per second, burst of 4 requests
-			clients[ip] = &client{limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst)}
+			clients[ip] = &client{limiter: rate.NewLimiter(rate.Limit(rps), burst)}
 		}
 
 		clients[ip].lastSeen = time.Now()
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"mime"
 	"net/http"
+	"net/url"
+	"slices"
 
 	"greenlight/internal/data"
+	"greenlight/internal/patch"
 	"greenlight/internal/validator"
 )
 
@@ -16,6 +21,8 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		Year    int          `json:"year"`
 		Runtime data.Runtime `json:"runtime"`
 		Genres  []string     `json:"genres"`
+
+		ExternalIDs map[string]string `json:"external_ids"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -29,10 +36,20 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		Year:    input.Year,
 		Runtime: input.Runtime,
 		Genres:  input.Genres,
+
+		ExternalIDs: input.ExternalIDs,
 	}
 
 	v := validator.New()
 
+	movie.RuntimeFormat = app.readRuntimeFormat(r.URL.Query(), v)
+
+	err = app.normalizeGenres(r.Context(), v, &movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	data.ValidateMovie(v, &movie)
 
 	if !v.Valid() {
@@ -40,10 +57,16 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Insert(&movie)
+	err = app.models.Movies.Insert(r.Context(), &movie, app.contextGetUser(r).ID)
 
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrDuplicateExternalID):
+			v.AddError("external_ids", "another movie already has one of these ids")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -64,8 +87,24 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	//var movie data.Movie
-	movie, err := app.models.Movies.Get(id)
+	v := validator.New()
+
+	fields := app.readCSV(r.URL.Query(), "fields", nil)
+	include := app.readCSV(r.URL.Query(), "include", nil)
+
+	languages := app.readLanguages(r, v)
+	runtimeFormat := app.readRuntimeFormat(r.URL.Query(), v)
+
+	data.ValidateFields(v, fields, data.MovieFieldSafeList)
+	for _, name := range include {
+		v.Check(validator.PermittedValue(name, "credits"), "include", "invalid value "+name)
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie, err := app.models.Movies.GetFields(r.Context(), id, fields)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -76,7 +115,34 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	movie.RuntimeFormat = runtimeFormat
+
+	if len(fields) == 0 || slices.Contains(fields, "title") {
+		// the id isn't selected with every set of fields
+		movie.ID = id
+		err = app.models.Titles.Localize(r.Context(), []*data.Movie{movie}, languages)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if validator.PermittedValue("credits", include...) {
+		movie.Credits, err = app.models.People.GetCreditsForMovie(r.Context(), id)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	var body any = movie
+	if len(fields) > 0 {
+		body = movie.Project(fields)
+	}
+
+	w.Header().Add("Vary", "Accept-Language")
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": body}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -91,7 +157,7 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	//get movie with a certain id
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -102,40 +168,84 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// using points is because of the go type for pointer have zero value nil
-	// useful for distinguising betwen empty and not arguments not passed
-	var input struct {
-		Title   *string       `json:"title"`
-		Year    *int          `json:"year"`
-		Runtime *data.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
+	// plain json keeps working as a partial update, the patch formats are picked by content type
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/json"
 	}
 
-	// decode from json
-	err = app.readJSON(w, r, &input)
+	mediaType, _, err := mime.ParseMediaType(contentType)
 	if err != nil {
-		app.badRequestResponse(w, r, err)
+		app.unsupportedMediaTypeResponse(w, r)
 		return
 	}
 
-	// subtitute values
-	if input.Title != nil {
-		movie.Title = *input.Title
-	}
-	if input.Year != nil {
-		movie.Year = *input.Year
-	}
+	switch mediaType {
+	case "application/merge-patch+json":
+		if !app.patchMovie(w, r, movie, patch.Merge) {
+			return
+		}
 
-	if input.Runtime != nil {
-		movie.Runtime = *input.Runtime
-	}
+	case "application/json-patch+json":
+		if !app.patchMovie(w, r, movie, patch.Apply) {
+			return
+		}
+
+	case "application/json":
+		// using points is because of the go type for pointer have zero value nil
+		// useful for distinguising betwen empty and not arguments not passed
+		var input struct {
+			Title   *string       `json:"title"`
+			Year    *int          `json:"year"`
+			Runtime *data.Runtime `json:"runtime"`
+			Genres  []string      `json:"genres"`
+
+			// replaces all of them, {} removes them
+			ExternalIDs map[string]string `json:"external_ids"`
+		}
 
-	if input.Genres != nil {
-		movie.Genres = input.Genres
+		// decode from json
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		// subtitute values
+		if input.Title != nil {
+			movie.Title = *input.Title
+		}
+		if input.Year != nil {
+			movie.Year = *input.Year
+		}
+
+		if input.Runtime != nil {
+			movie.Runtime = *input.Runtime
+		}
+
+		if input.Genres != nil {
+			movie.Genres = input.Genres
+		}
+
+		if input.ExternalIDs != nil {
+			movie.ExternalIDs = input.ExternalIDs
+		}
+
+	default:
+		app.unsupportedMediaTypeResponse(w, r)
+		return
 	}
 
 	v := validator.New()
 
+	movie.RuntimeFormat = app.readRuntimeFormat(r.URL.Query(), v)
+
+	err = app.normalizeGenres(r.Context(), v, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	data.ValidateMovie(v, movie)
 
 	if !v.Valid() {
@@ -144,11 +254,14 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	// update on the database
-	err = app.models.Movies.Update(movie)
+	err = app.models.Movies.Update(r.Context(), movie, app.contextGetUser(r).ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateExternalID):
+			v.AddError("external_ids", "another movie already has one of these ids")
+			app.failedValidationResponse(w, r, v.Errors)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -171,7 +284,7 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Delete(id)
+	err = app.models.Movies.Delete(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -189,13 +302,43 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 
 }
 
-func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
+// readMovieQuery reads the filters shared by the listing and the export, the page is left to the caller.
+// Genres are filtered by slug so they are normalized like the ones of the movies
+func (app *application) readMovieQuery(ctx context.Context, qs url.Values, v *validator.Validator) (data.MovieQuery, error) {
+	var input data.MovieQuery
 
-	var input struct {
-		Title   string
-		Genres  []string
-		Filters data.Filters
+	input.Title = app.readString(qs, "title", "")
+	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.Search = data.SearchMode(app.readString(qs, "search", string(data.SearchFullText)))
+	input.Fields = app.readCSV(qs, "fields", nil)
+	input.PersonID = app.readInt(qs, "person", 0, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+
+	input.Filters.SortSafeList = []string{"title", "id", "year", "runtime", "rating", "votes", "-id", "-title", "-year", "-runtime", "-rating", "-votes", "relevance"}
+
+	v.Check(validator.PermittedValue(input.Search, data.SearchModeSafeList...), "search", "must be fulltext or fuzzy")
+	data.ValidateFields(v, input.Fields, data.MovieFieldSafeList)
+	v.Check(input.PersonID >= 0, "person", "must be a positive integer")
+
+	resolver, err := app.models.Genres.Resolver(ctx)
+	if err != nil {
+		return input, err
 	}
+	input.Genres = resolver.Normalize(v, input.Genres)
+
+	return input, nil
+}
+
+// readRuntimeFormat reads the format runtimes are written in, see data.RuntimeFormatSafeList
+func (app *application) readRuntimeFormat(qs url.Values, v *validator.Validator) data.RuntimeFormat {
+	format := data.RuntimeFormat(app.readString(qs, "runtime_format", string(data.RuntimeMins)))
+
+	v.Check(validator.PermittedValue(format, data.RuntimeFormatSafeList...), "runtime_format", "must be mins, minutes, hm or iso8601")
+
+	return format
+}
+
+func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
 
 	v := validator.New()
 
@@ -203,13 +346,28 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	qs := r.URL.Query()
 
 	// extract values
-	input.Title = app.readString(qs, "title", "")
-	input.Genres = app.readCSV(qs, "genres", []string{})
+	input, err := app.readMovieQuery(r.Context(), qs, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
-	input.Filters.Sort = app.readString(qs, "sort", "id")
 
-	input.Filters.SortSafeList = []string{"title", "id", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	if app.readBool(qs, "highlight", false, v) {
+		input.Highlight = &app.config.highlight
+	}
+
+	languages := app.readLanguages(r, v)
+	runtimeFormat := app.readRuntimeFormat(qs, v)
+
+	// localized titles are looked up by id, so it's selected even when the client didn't ask for it
+	fields := input.Fields
+	localize := len(languages) > 0 && (len(fields) == 0 || slices.Contains(fields, "title"))
+	if localize && len(fields) > 0 && !slices.Contains(fields, "id") {
+		input.Fields = append(slices.Clone(fields), "id")
+	}
 
 	data.ValidateFilters(v, &input.Filters)
 	if !v.Valid() {
@@ -217,16 +375,58 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, metadata, err := app.models.Movies.GetAll(r.Context(), input)
 
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"movies": movies, "metadata": metadata}, nil)
+	for _, movie := range movies {
+		movie.RuntimeFormat = runtimeFormat
+	}
+
+	if localize {
+		err = app.models.Titles.Localize(r.Context(), movies, languages)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	w.Header().Add("Vary", "Accept-Language")
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"movies": data.ProjectMovies(movies, fields), "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+
+}
+
+// suggestMoviesHandler returns title completions for what the user has typed so far
+func (app *application) suggestMoviesHandler(w http.ResponseWriter, r *http.Request) {
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	q := app.readString(qs, "q", "")
+	limit := app.readInt(qs, "limit", 10, v)
+
+	data.ValidateSuggestion(v, q, limit)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	suggestions, err := app.models.Movies.Suggest(r.Context(), q, limit)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
 	}
 
+	err = app.writeJSON(w, http.StatusOK, envelope{"suggestions": suggestions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
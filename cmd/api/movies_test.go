@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"greenlight/internal/data"
+)
+
+// TestMovieHandlersInMemory runs the movie handlers through the router on the memory models
+func TestMovieHandlersInMemory(t *testing.T) {
+	ctx := context.Background()
+
+	app := &application{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		models: data.NewMemoryModels(),
+	}
+	routes := app.routes()
+
+	user := &data.User{Name: "Alice", Email: "alice@example.com", Ativated: true}
+	err := app.models.Users.Insert(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.models.Permissions.AddForUser(ctx, user.ID, "movies:read", "movies:write")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := app.models.Tokens.New(ctx, user.ID, time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(method, url, body string, header http.Header) (int, map[string]json.RawMessage) {
+		t.Helper()
+
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		for name, values := range header {
+			r.Header[name] = values
+		}
+		if r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", "Bearer "+token.Plaintext)
+		}
+
+		w := httptest.NewRecorder()
+		routes.ServeHTTP(w, r)
+
+		var response map[string]json.RawMessage
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		if err != nil {
+			t.Fatalf("%s %s: %v in %s", method, url, err, w.Body)
+		}
+		return w.Code, response
+	}
+
+	var movie data.Movie
+	status, response := request(http.MethodPost, "/v1/movies", `{"title":"Alien","year":1979,"runtime":"117 mins","genres":["Sci-Fi","horror"]}`, nil)
+	if status != http.StatusCreated {
+		t.Fatalf("got %d creating a movie: %s", status, response["error"])
+	}
+	err = json.Unmarshal(response["movie"], &movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(movie.Genres, []string{"science-fiction", "horror"}) {
+		t.Errorf("got genres %q, want the canonical slugs", movie.Genres)
+	}
+
+	status, response = request(http.MethodPost, "/v1/movies", `{"title":"Heat","year":1995,"runtime":"170 mins","genres":["space opera"]}`, nil)
+	if status != http.StatusUnprocessableEntity {
+		t.Errorf("got %d creating a movie with an unknown genre, want 422", status)
+	}
+
+	// there are no localized titles in memory, the original one is kept
+	status, response = request(http.MethodGet, "/v1/movies/1", "", http.Header{"Accept-Language": {"es-MX, es"}})
+	if status != http.StatusOK {
+		t.Fatalf("got %d showing a movie: %s", status, response["error"])
+	}
+	movie = data.Movie{}
+	err = json.Unmarshal(response["movie"], &movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if movie.Title != "Alien" {
+		t.Errorf("got title %q, want Alien", movie.Title)
+	}
+
+	var movies []*data.Movie
+	status, response = request(http.MethodGet, "/v1/movies?genres=scifi", "", http.Header{"Accept-Language": {"es"}})
+	if status/100 != 2 {
+		t.Fatalf("got %d listing movies: %s", status, response["error"])
+	}
+	err = json.Unmarshal(response["movies"], &movies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(movies) != 1 || movies[0].Title != "Alien" {
+		t.Errorf("got %d movies listing science fiction, want Alien", len(movies))
+	}
+
+	var genres []*data.Genre
+	status, response = request(http.MethodGet, "/v1/genres", "", nil)
+	if status != http.StatusOK {
+		t.Fatalf("got %d listing genres: %s", status, response["error"])
+	}
+	err = json.Unmarshal(response["genres"], &genres)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, genre := range genres {
+		if genre.Slug == "horror" && genre.Movies != 1 {
+			t.Errorf("got %d horror movies, want 1", genre.Movies)
+		}
+	}
+
+	status, _ = request(http.MethodGet, "/v1/movies", "", http.Header{"Authorization": {"Bearer " + strings.Repeat("A", 26)}})
+	if status != http.StatusBadRequest {
+		t.Errorf("got %d with an unknown token, want 400", status)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+)
+
+// deliverEmails launches the goroutine that sends the emails queued in the outbox. It checks for due
+// emails once every poll interval, or right away when woken up by wakeOutbox. It stops once ctx is canceled,
+// the email being sent then is still marked and serve waits for it on app.wg
+func (app *application) deliverEmails(ctx context.Context) {
+	app.wg.Go(func() {
+		ticker := time.NewTicker(app.config.outbox.pollInterval)
+		defer ticker.Stop()
+
+		for {
+			// a full batch means more emails may be waiting
+			for ctx.Err() == nil && app.deliverBatch(ctx) == app.config.outbox.batchSize {
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-app.outboxWake:
+			}
+		}
+	})
+}
+
+// deliverBatch sends one batch of due emails and returns how many it claimed
+func (app *application) deliverBatch(ctx context.Context) (claimed int) {
+	// same as background, a panic here must not take the server down
+	defer func() {
+		pv := recover()
+		if pv != nil {
+			app.logger.Error(fmt.Sprintf("%v", pv))
+		}
+	}()
+
+	// taken before claiming, so the lease is never over later here than it is in the outbox
+	leaseEnd := time.Now().Add(app.config.outbox.lease)
+
+	emails, err := app.models.Outbox.Claim(ctx, app.config.outbox.batchSize, app.config.outbox.lease)
+	if err != nil {
+		if !errors.Is(err, data.ErrQueryCanceled) {
+			app.logger.Error(err.Error())
+		}
+		return 0
+	}
+
+	// an email that was sent has to be marked so even when shutting down, or it would go out again
+	mark := context.WithoutCancel(ctx)
+
+	for _, email := range emails {
+		// the rest are claimed again once their lease runs out. Past it they may have been already,
+		// sending them here too would send them twice
+		if ctx.Err() != nil || !time.Now().Before(leaseEnd) {
+			break
+		}
+
+		sendErr := app.mailer.Send(email.MessageID(), email.Recipient, email.Template, email.Data)
+		if sendErr == nil {
+			err = app.models.Outbox.MarkSent(mark, email)
+		} else {
+			err = app.models.Outbox.MarkFailed(mark, email, sendErr, app.config.outbox.retry)
+		}
+		switch {
+		case errors.Is(err, data.ErrLeaseLost):
+			app.logger.Warn("email lease lost, it was claimed again while being sent", "email_id", email.ID)
+			continue
+		case err != nil:
+			app.logger.Error(err.Error(), "email_id", email.ID)
+			continue
+		}
+
+		switch email.Status {
+		case data.OutboxSent:
+			app.logger.Info("email sent", "email_id", email.ID, "template", email.Template)
+		case data.OutboxDead:
+			app.logger.Error("email dead-lettered", "email_id", email.ID, "attempts", email.Attempts, "error", email.LastError)
+		default:
+			app.logger.Warn("email failed, will retry", "email_id", email.ID, "attempts", email.Attempts,
+				"next_attempt_at", email.NextAttemptAt, "error", email.LastError)
+		}
+	}
+
+	return len(emails)
+}
+
+// wakeOutbox tells the outbox worker there are emails to send, without waiting on it
+func (app *application) wakeOutbox() {
+	select {
+	case app.outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// listOutboxHandler lists the emails in the outbox, the failed ones can be looked up with status=dead
+func (app *application) listOutboxHandler(w http.ResponseWriter, r *http.Request) {
+
+	var filters data.Filters
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	status := app.readString(qs, "status", "")
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-id")
+
+	filters.SortSafeList = []string{"id", "created_at", "next_attempt_at", "-id", "-created_at", "-next_attempt_at"}
+
+	if status != "" {
+		v.Check(validator.PermittedValue(status, data.OutboxStatusSafeList...), "status", "must be pending, sent or dead")
+	}
+	data.ValidateFilters(v, &filters)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	emails, metadata, err := app.models.Outbox.GetAll(r.Context(), status, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"emails": emails, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showOutboxEmailHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	email, err := app.models.Outbox.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"email": email}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// retryOutboxEmailHandler sends a dead (or still pending) email again right away, with all its attempts
+func (app *application) retryOutboxEmailHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	email, err := app.models.Outbox.Retry(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrAlreadySent):
+			app.errorResponse(w, r, http.StatusConflict, "the email has already been sent")
+		case errors.Is(err, data.ErrEditConflict):
+			// the worker is sending it right now
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.wakeOutbox()
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"email": email}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
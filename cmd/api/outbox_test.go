@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"greenlight/internal/data"
+	"greenlight/internal/mailer"
+)
+
+func TestDeliverBatch(t *testing.T) {
+	ctx := context.Background()
+	sender := mailer.NewMemory("Greenlight <no-reply@example.com>")
+
+	app := &application{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		models: data.NewMemoryModels(),
+		mailer: sender,
+	}
+	app.config.outbox.batchSize = 10
+	app.config.outbox.lease = time.Minute
+	app.config.outbox.retry = data.OutboxRetry{MaxAttempts: 3, Backoff: time.Hour, MaxBackoff: time.Hour}
+
+	welcome := &data.OutboxEmail{
+		IdempotencyKey: "user_welcome:1",
+		Recipient:      "alice@example.com",
+		Template:       "user_welcome.tmpl",
+		Data:           map[string]any{"activationToken": "AAAAAAAAAAAAAAAAAAAAAAAAAA", "userID": 1},
+	}
+	err := app.models.Outbox.Enqueue(ctx, welcome)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a failed attempt leaves the email for later
+	sender.Fail(errors.New("smtp: connection refused"))
+	if n := app.deliverBatch(ctx); n != 1 {
+		t.Fatalf("got %d emails claimed, want 1", n)
+	}
+	if len(sender.Messages()) != 0 {
+		t.Fatal("got a message sent while sending fails")
+	}
+
+	email, err := app.models.Outbox.Get(ctx, welcome.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if email.Status != data.OutboxPending || email.Attempts != 1 || email.LastError == "" {
+		t.Errorf("got %+v, want it pending after a failed attempt", email)
+	}
+
+	// retrying makes it due right away
+	sender.Fail(nil)
+	_, err = app.models.Outbox.Retry(ctx, welcome.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := app.deliverBatch(ctx); n != 1 {
+		t.Fatalf("got %d emails claimed, want 1", n)
+	}
+
+	messages := sender.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+	if messages[0].To != "alice@example.com" || messages[0].ID != welcome.MessageID() {
+		t.Errorf("got %+v", messages[0])
+	}
+	if !strings.Contains(messages[0].PlainBody, "AAAAAAAAAAAAAAAAAAAAAAAAAA") {
+		t.Errorf("got body %q, want the activation token in it", messages[0].PlainBody)
+	}
+
+	email, err = app.models.Outbox.Get(ctx, welcome.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if email.Status != data.OutboxSent {
+		t.Errorf("got status %q, want sent", email.Status)
+	}
+
+	// nothing is left to send
+	if n := app.deliverBatch(ctx); n != 0 {
+		t.Errorf("got %d emails claimed, want none", n)
+	}
+
+	// nothing is sent once the lease is over, another worker may have the emails by then
+	err = app.models.Outbox.Enqueue(ctx, &data.OutboxEmail{
+		IdempotencyKey: "user_welcome:2",
+		Recipient:      "bob@example.com",
+		Template:       "user_welcome.tmpl",
+		Data:           map[string]any{"activationToken": "BBBBBBBBBBBBBBBBBBBBBBBBBB", "userID": 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.config.outbox.lease = -time.Second
+	if n := app.deliverBatch(ctx); n != 1 {
+		t.Fatalf("got %d emails claimed, want 1", n)
+	}
+	if len(sender.Messages()) != 1 {
+		t.Errorf("got %d messages, want none sent past the lease", len(sender.Messages())-1)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"greenlight/internal/data"
+	"greenlight/internal/patch"
+)
+
+// moviePatchDocument is the JSON a patch is applied to. version is part of it so a client can
+// guard its change with {"op": "test", "path": "/version", "value": 3} or by sending it in a merge patch
+type moviePatchDocument struct {
+	Title   string       `json:"title"`
+	Year    int          `json:"year"`
+	Runtime data.Runtime `json:"runtime"`
+	Genres  []string     `json:"genres"`
+	// always an object, so a json patch can add to it directly, e.g. {"op": "add", "path": "/external_ids/imdb", ...}
+	ExternalIDs map[string]string `json:"external_ids"`
+	Version     int               `json:"version"`
+}
+
+// patchMovie applies a merge patch or a json patch (chosen by apply) from the request body to the movie.
+// It sends the error response itself and returns false when the movie couldn't be patched
+func (app *application) patchMovie(w http.ResponseWriter, r *http.Request, movie *data.Movie, apply func(doc, patch []byte) ([]byte, error)) bool {
+	var body json.RawMessage
+
+	err := app.readJSON(w, r, &body)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return false
+	}
+
+	externalIDs := map[string]string(movie.ExternalIDs)
+	if externalIDs == nil {
+		externalIDs = map[string]string{}
+	}
+
+	doc, err := json.Marshal(moviePatchDocument{
+		Title:       movie.Title,
+		Year:        movie.Year,
+		Runtime:     movie.Runtime,
+		Genres:      movie.Genres,
+		ExternalIDs: externalIDs,
+		Version:     movie.Version,
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	patched, err := apply(doc, body)
+	if err != nil {
+		var opError *patch.OperationError
+		switch {
+		case errors.Is(err, patch.ErrInvalidPatch):
+			app.badRequestResponse(w, r, err)
+		case errors.Is(err, patch.ErrTestFailed):
+			app.editConflictResponse(w, r)
+		case errors.As(err, &opError):
+			app.failedValidationResponse(w, r, map[string]string{"patch": err.Error()})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return false
+	}
+
+	// the patched document has to still look like a movie, e.g. a patch can't add unknown keys
+	var result moviePatchDocument
+
+	dec := json.NewDecoder(bytes.NewReader(patched))
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(&result)
+	if err != nil {
+		app.failedValidationResponse(w, r, map[string]string{"patch": "result is not a valid movie: " + err.Error()})
+		return false
+	}
+
+	if result.Version != movie.Version {
+		app.editConflictResponse(w, r)
+		return false
+	}
+
+	movie.Title = result.Title
+	movie.Year = result.Year
+	movie.Runtime = result.Runtime
+	movie.Genres = result.Genres
+	movie.ExternalIDs = result.ExternalIDs
+
+	return true
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+)
+
+// listMovieRevisionsHandler returns the saved versions of a movie, newest first by default
+func (app *application) listMovieRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var filters data.Filters
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-version")
+
+	filters.SortSafeList = []string{"version", "-version"}
+
+	data.ValidateFilters(v, &filters)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// deleted movies keep their history but it's hidden together with them
+	_, err = app.models.Movies.GetFields(r.Context(), id, []string{"id"})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	revisions, metadata, err := app.models.Movies.GetRevisions(r.Context(), id, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"revisions": revisions, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// diffMovieRevisionsHandler compares two versions of a movie, ?from= is required and ?to= defaults to the current version
+func (app *application) diffMovieRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.GetFields(r.Context(), id, []string{"id", "version"})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	from := app.readInt(qs, "from", 0, v)
+	to := app.readInt(qs, "to", movie.Version, v)
+
+	v.Check(from > 0, "from", "must be provided")
+	v.Check(from <= movie.Version, "from", "must not be greater than the current version")
+	v.Check(to > 0, "to", "must be greater than zero")
+	v.Check(to <= movie.Version, "to", "must not be greater than the current version")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	revisions := make([]*data.MovieRevision, 2)
+	for i, version := range []int{from, to} {
+		revisions[i], err = app.models.Movies.GetRevision(r.Context(), id, version)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	diff := envelope{
+		"from":    from,
+		"to":      to,
+		"changes": data.DiffRevisions(revisions[0], revisions[1]),
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"diff": diff}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// restoreMovieRevisionHandler rolls a movie back to an older version. The rollback is saved as a new
+// version on top of the current one, so the history is never rewritten
+func (app *application) restoreMovieRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	version, err := app.readIntParam(r, "version")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	revision, err := app.models.Movies.GetRevision(r.Context(), id, version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	revision.Apply(movie)
+
+	// old versions were valid under the rules of their time, they still have to pass today's
+	// (and may predate the genre taxonomy)
+	v := validator.New()
+
+	err = app.normalizeGenres(r.Context(), v, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data.ValidateMovie(v, movie)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Movies.Update(r.Context(), movie, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -21,18 +21,105 @@ func (app *application) routes() http.Handler {
 	// require authetication routes
 	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMoviesHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id", app.staticOrID(map[string]http.HandlerFunc{
+		"import": app.requirePermission("movies:write", app.importMoviesHandler),
+	}, nil))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.staticOrID(map[string]http.HandlerFunc{
+		"suggest":    app.requirePermission("movies:read", app.suggestMoviesHandler),
+		"export":     app.requirePermission("movies:read", app.exportMoviesHandler),
+		"trash":      app.requirePermission("movies:write", app.listTrashHandler),
+		"duplicates": app.requirePermission("movies:read", app.listDuplicatesHandler),
+	}, app.requirePermission("movies:read", app.showMovieHandler)))
 	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/merge", app.requirePermission("movies:write", app.mergeMovieHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/restore", app.requirePermission("movies:write", app.restoreMovieHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/revisions", app.requirePermission("movies:read", app.listMovieRevisionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/diff", app.requirePermission("movies:read", app.diffMovieRevisionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/revisions/:version/restore", app.requirePermission("movies:write", app.restoreMovieRevisionHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/poster", app.requirePermission("movies:write", app.uploadPosterHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/poster", app.requirePermission("movies:write", app.deletePosterHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/credits", app.requirePermission("movies:write", app.createCreditHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/credits/:credit_id", app.requirePermission("movies:write", app.deleteCreditHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/similar", app.requirePermission("movies:read", app.listSimilarMoviesHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/titles", app.requirePermission("movies:read", app.listTitlesHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/titles/:language", app.requirePermission("movies:write", app.putTitleHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/titles/:language", app.requirePermission("movies:write", app.deleteTitleHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/reviews", app.requirePermission("movies:read", app.listReviewsHandler))
+	// any activated user can review, but only their own reviews can be changed
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/reviews", app.requireActivatedUser(app.createReviewHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id/reviews/:review_id", app.requireActivatedUser(app.updateReviewHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/reviews/:review_id", app.requireActivatedUser(app.deleteReviewHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/genres", app.requirePermission("movies:read", app.listGenresHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/people", app.requirePermission("movies:read", app.listPeopleHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/people", app.requirePermission("movies:write", app.createPersonHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/people/:id", app.requirePermission("movies:read", app.showPersonHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/people/:id", app.requirePermission("movies:write", app.updatePersonHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/people/:id", app.requirePermission("movies:write", app.deletePersonHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/people/:id/movies", app.requirePermission("movies:read", app.listFilmographyHandler))
+
+	// editing a collection is checked against its owner inside the handlers
+	router.HandlerFunc(http.MethodGet, "/v1/collections", app.requirePermission("movies:read", app.listCollectionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/collections", app.requireActivatedUser(app.createCollectionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/collections/:id", app.requirePermission("movies:read", app.showCollectionHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/collections/:id", app.requireActivatedUser(app.updateCollectionHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/collections/:id", app.requireActivatedUser(app.deleteCollectionHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/collections/:id/movies", app.requireActivatedUser(app.addCollectionMovieHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/collections/:id/movies", app.requireActivatedUser(app.reorderCollectionHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/collections/:id/movies/:movie_id", app.requireActivatedUser(app.removeCollectionMovieHandler))
 
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	// using PUT is more appropriete then POST because it does not change the application state
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/users/authentication", app.createAuthenticationTokenHandler)
 
-	// using PUT is more appropriete then POST because it does not change the application state
-	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/watchlist", app.requireActivatedUser(app.listWatchlistHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/watchlist", app.requireActivatedUser(app.addToWatchlistHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/watchlist/:movie_id", app.requireActivatedUser(app.removeFromWatchlistHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/watched", app.requireActivatedUser(app.listWatchedHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/watched", app.requireActivatedUser(app.addWatchedHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/watched/:id", app.requireActivatedUser(app.deleteWatchedHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/outbox", app.requirePermission("outbox:admin", app.listOutboxHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/outbox/:id", app.requirePermission("outbox:admin", app.showOutboxEmailHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/outbox/:id/retry", app.requirePermission("outbox:admin", app.retryOutboxEmailHandler))
+
+	// backends that keep files on this machine serve them too, from the path of the storage url
+	if files, ok := app.storage.(http.Handler); ok {
+		prefix := app.storagePath()
+		router.Handler(http.MethodGet, prefix+"/*filepath", http.StripPrefix(prefix, files))
+	}
+
+	handler := app.authenticate(router)
+
+	// suggestions get their own limiter instead of sharing the global one
+	limited := http.NewServeMux()
+	limited.Handle("/", app.rateLimit(app.config.limiter.rps, app.config.limiter.burst, handler))
+	limited.Handle("/v1/movies/suggest", app.rateLimit(app.config.limiter.suggestRps, app.config.limiter.suggestBurst, handler))
 
 	//wrap the router with panic recovery
-	return app.recoverPanic(app.rateLimit(app.authenticate(router)))
+	return app.recoverPanic(limited)
+}
+
+// staticOrID lets fixed paths like /v1/movies/suggest live next to /v1/movies/:id.
+// httprouter panics when both are registered, so the fixed ones are matched on the :id value instead
+func (app *application) staticOrID(static map[string]http.HandlerFunc, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+
+		handler, ok := static[params.ByName("id")]
+		if ok {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		// with no :id handler for this method the path only exists for the other ones
+		if next == nil {
+			app.methodNotAllowedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
 }
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -14,6 +15,11 @@ import (
 
 func (app *application) serve() error {
 
+	// every request context derives from base, canceling it cancels the queries of the requests
+	// still running when the shutdown grace period is over, and stops the trash purge and the outbox worker
+	base, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
+
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", app.config.port),
 		Handler:      app.routes(),
@@ -21,8 +27,12 @@ func (app *application) serve() error {
 		IdleTimeout:  1 * time.Minute,
 		ReadTimeout:  2 * time.Second,
 		WriteTimeout: 10 * time.Second,
+		BaseContext:  func(net.Listener) context.Context { return base },
 	}
 
+	app.purgeTrash(base)
+	app.deliverEmails(base)
+
 	shutdownError := make(chan error)
 
 	// start a background goroutine
@@ -42,6 +52,8 @@ func (app *application) serve() error {
 		defer cancel()
 		//we only send it to shutdown channel if it returns an error
 		err := srv.Shutdown(ctx)
+		// whatever is still running past the grace period gets its queries canceled
+		cancelBase()
 		if err != nil {
 			shutdownError <- err
 		}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+)
+
+// listSimilarMoviesHandler recommends movies like the one in the url, see data.SimilarWeights for how they're ranked
+func (app *application) listSimilarMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	filters := data.Filters{
+		Sort:         "-score",
+		SortSafeList: []string{"-score"},
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	languages := app.readLanguages(r, v)
+	runtimeFormat := app.readRuntimeFormat(qs, v)
+
+	data.ValidateFilters(v, &filters)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !app.movieExists(w, r, id) {
+		return
+	}
+
+	movies, metadata, err := app.models.Movies.GetSimilar(r.Context(), id, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for _, movie := range movies {
+		movie.RuntimeFormat = runtimeFormat
+	}
+
+	err = app.models.Titles.Localize(r.Context(), movies, languages)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Add("Vary", "Accept-Language")
+
+	err = app.writeCachedJSON(w, r, envelope{"movies": movies, "metadata": metadata}, app.config.similar.maxAge)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
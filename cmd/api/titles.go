@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/text/language"
+)
+
+// readLanguages reads the languages the client wants titles in, best first. The lang parameter (one tag or
+// a list of them) wins over the Accept-Language header, a malformed header is ignored like a missing one
+func (app *application) readLanguages(r *http.Request, v *validator.Validator) []string {
+	langs := app.readCSV(r.URL.Query(), "lang", nil)
+	if len(langs) > 0 {
+		tags := make([]language.Tag, 0, len(langs))
+		for _, lang := range langs {
+			tag, err := language.Parse(lang)
+			if err != nil {
+				v.AddError("lang", "must be a valid language tag, e.g. es or pt-BR")
+				return nil
+			}
+			tags = append(tags, tag)
+		}
+		return data.PreferredLanguages(tags)
+	}
+
+	tags, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	if err != nil {
+		return nil
+	}
+	return data.PreferredLanguages(tags)
+}
+
+func (app *application) listTitlesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if !app.movieExists(w, r, id) {
+		return
+	}
+
+	titles, err := app.models.Titles.GetAllForMovie(r.Context(), id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"titles": titles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// putTitleHandler sets the title of a movie in the language of the url, e.g. PUT /v1/movies/1/titles/es
+func (app *application) putTitleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Title string `json:"title"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	title := data.LocalizedTitle{
+		MovieID:  id,
+		Language: httprouter.ParamsFromContext(r.Context()).ByName("language"),
+		Title:    input.Title,
+	}
+
+	v := validator.New()
+
+	data.ValidateLocalizedTitle(v, &title)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// stored canonicalized, so "pt-br" and "pt-BR" are the same title
+	title.Language, _ = data.ParseLanguage(title.Language)
+
+	if !app.movieExists(w, r, id) {
+		return
+	}
+
+	created, err := app.models.Titles.Put(r.Context(), &title)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+
+	err = app.writeJSON(w, status, envelope{"title": title}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteTitleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	lang, ok := data.ParseLanguage(httprouter.ParamsFromContext(r.Context()).ByName("language"))
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Titles.Delete(r.Context(), id, lang)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "title successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -35,7 +35,7 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 
 	// fecth the user with this email to compare passwords
 	// maybe check if the user is verified first ?
-	user, err := app.models.Users.GetByEmail(input.Email)
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
 
 	if err != nil {
 		switch {
@@ -58,7 +58,7 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	token, err := app.models.Tokens.New(r.Context(), user.ID, 24*time.Hour, data.ScopeAuthentication)
 
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+)
+
+// listTrashHandler lists deleted movies that can still be restored, most recently deleted first
+func (app *application) listTrashHandler(w http.ResponseWriter, r *http.Request) {
+
+	var filters data.Filters
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-deleted_at")
+
+	filters.SortSafeList = []string{"id", "title", "deleted_at", "-id", "-title", "-deleted_at"}
+
+	data.ValidateFilters(v, &filters)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, metadata, err := app.models.Movies.GetTrash(r.Context(), filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) restoreMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Restore(r.Context(), id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// purgeTrash launches a goroutine that, once every interval, permanently deletes the movies
+// that have been in the trash for longer than the retention period. A retention of 0 keeps them forever.
+// It stops once ctx is canceled
+func (app *application) purgeTrash(ctx context.Context) {
+	if app.config.trash.retention <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(app.config.trash.purgeInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			func() {
+				// same as background, a panic here must not take the server down
+				defer func() {
+					pv := recover()
+					if pv != nil {
+						app.logger.Error(fmt.Sprintf("%v", pv))
+					}
+				}()
+
+				purged, keys, err := app.models.Movies.Purge(ctx, app.config.trash.retention)
+				if err != nil {
+					app.logger.Error(err.Error())
+					return
+				}
+				if purged > 0 {
+					app.logger.Info("purged movies from the trash", "count", purged)
+				}
+
+				// the poster files of the purged movies aren't used by anything anymore
+				app.deleteKeys(keys)
+			}()
+		}
+	}()
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"greenlight/internal/data"
 	"greenlight/internal/validator"
 	"net/http"
@@ -42,7 +43,34 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.Users.Insert(user)
+	// all or nothing, otherwise a failure half way leaves a user without permissions or that can't be activated
+	err = app.models.InTx(r.Context(), func(models data.Models) error {
+		err := models.Users.Insert(r.Context(), user)
+		if err != nil {
+			return err
+		}
+
+		err = models.Permissions.AddForUser(r.Context(), user.ID, "movies:read")
+		if err != nil {
+			return err
+		}
+
+		token, err := models.Tokens.New(r.Context(), user.ID, 3*24*time.Hour, data.ScopeActivation)
+		if err != nil {
+			return err
+		}
+
+		// queued with the user, so there's never a user that didn't get the email or an email without a user
+		return models.Outbox.Enqueue(r.Context(), &data.OutboxEmail{
+			IdempotencyKey: fmt.Sprintf("user_welcome:%d", user.ID),
+			Recipient:      user.Email,
+			Template:       "user_welcome.tmpl",
+			Data: map[string]any{
+				"activationToken": token.Plaintext,
+				"userID":          user.ID,
+			},
+		})
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEmail):
@@ -55,31 +83,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-
-	err = app.models.Permissions.AddForUser(user.ID, "movies:read")
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-
-	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	app.background(func() {
-		data := map[string]any{
-			"activationToken": token.Plaintext,
-			"userID":          user.ID,
-		}
-
-		err = app.mailer.Send(user.Email, "user_welcome.tmpl", data)
-		if err != nil {
-			app.logger.Error(err.Error())
-		}
-	})
+	app.wakeOutbox()
 
 	// 202 Accepted
 	err = app.writeJSON(w, http.StatusAccepted, envelope{"user": user}, nil)
@@ -108,23 +112,29 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	user, err := app.models.Users.GetForToken(data.ScopeActivation, input.TokenPlainText)
+	var user *data.User
+
+	// the tokens are deleted along with the activation, a token must not outlive it or be used twice
+	err = app.models.InTx(r.Context(), func(models data.Models) error {
+		var err error
+		user, err = models.Users.GetForToken(r.Context(), data.ScopeActivation, input.TokenPlainText)
+		if err != nil {
+			return err
+		}
+
+		user.Ativated = true
+		err = models.Users.Update(r.Context(), user)
+		if err != nil {
+			return err
+		}
 
+		return models.Tokens.DeleteAllForUser(r.Context(), data.ScopeActivation, user.ID)
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			v.AddError("token", "invalid token")
 			app.failedValidationResponse(w, r, v.Errors)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
-		return
-	}
-
-	user.Ativated = true
-	err = app.models.Users.Update(user)
-	if err != nil {
-		switch {
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
 		default:
@@ -133,14 +143,6 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-
-	// if evertything went ok, delete tokens
-	err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
 	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
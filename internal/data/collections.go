@@ -0,0 +1,395 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"greenlight/internal/validator"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrDuplicateCollectionMovie = errors.New("movie already in collection")
+	ErrCollectionMismatch       = errors.New("movies don't match the collection")
+
+	// private collections are only seen by their owner, unlisted ones by anyone with the link
+	// and public ones are listed for everybody
+	CollectionVisibilities = []string{"private", "unlisted", "public"}
+)
+
+type Collection struct {
+	ID          int                `json:"id"`
+	CreatedAt   time.Time          `json:"created_at"`
+	UserID      int                `json:"user_id"`
+	Title       string             `json:"title"`
+	Description string             `json:"description,omitempty"`
+	Visibility  string             `json:"visibility"`
+	Movies      []*CollectionMovie `json:"movies,omitempty"` // only set when showing a single collection
+	Version     int                `json:"version"`
+}
+
+// CollectionMovie is a movie at its place in a collection, positions start at 1
+type CollectionMovie struct {
+	Position int    `json:"position"`
+	MovieID  int    `json:"movie_id"`
+	Title    string `json:"title"`
+	Year     int    `json:"year"`
+}
+
+type CollectionModel struct {
+	DB *DB
+}
+
+func ValidateCollection(v *validator.Validator, collection *Collection) {
+	v.Check(collection.Title != "", "title", "must be provided")
+	v.Check(len(collection.Title) <= 500, "title", "must not be more than 500 bytes long")
+	v.Check(len(collection.Description) <= 10_000, "description", "must not be more than 10000 bytes long")
+	v.Check(validator.PermittedValue(collection.Visibility, CollectionVisibilities...), "visibility", "must be private, unlisted or public")
+}
+
+func (m CollectionModel) Insert(ctx context.Context, collection *Collection) error {
+	query := `
+		INSERT INTO collections (user_id, title, description, visibility)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version`
+
+	args := []any{collection.UserID, collection.Title, collection.Description, collection.Visibility}
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Write)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&collection.ID, &collection.CreatedAt, &collection.Version)
+}
+
+// Get returns a collection without its movies, see GetMovies
+func (m CollectionModel) Get(ctx context.Context, id int) (*Collection, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, user_id, title, description, visibility, version
+		FROM collections
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Read)
+	defer cancel()
+
+	var collection Collection
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&collection.ID,
+		&collection.CreatedAt,
+		&collection.UserID,
+		&collection.Title,
+		&collection.Description,
+		&collection.Visibility,
+		&collection.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &collection, nil
+}
+
+func (m CollectionModel) Update(ctx context.Context, collection *Collection) error {
+	query := `
+		UPDATE collections
+		SET title = $1, description = $2, visibility = $3, version = version + 1
+		WHERE id = $4 AND version = $5
+		RETURNING version`
+
+	args := []any{collection.Title, collection.Description, collection.Visibility, collection.ID, collection.Version}
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Write)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&collection.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m CollectionModel) Delete(ctx context.Context, id int) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM collections
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Write)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetAll lists the public collections, or with a userID every collection of that user whatever its visibility
+func (m CollectionModel) GetAll(ctx context.Context, title string, userID int, filters Filters) ([]*Collection, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, user_id, title, description, visibility, version
+		FROM collections
+		WHERE (title ILIKE $1 OR $2 = '')
+		AND   (user_id = $3 OR ($3 = 0 AND visibility = 'public'))
+		ORDER BY %s
+		LIMIT $4 OFFSET $5`, filters.orderBy())
+
+	args := []any{"%" + escapeLike(title) + "%", title, userID, filters.limit(), filters.offset()}
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Search)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	collections := []*Collection{}
+	for rows.Next() {
+		var collection Collection
+		err := rows.Scan(
+			&totalRecords,
+			&collection.ID,
+			&collection.CreatedAt,
+			&collection.UserID,
+			&collection.Title,
+			&collection.Description,
+			&collection.Visibility,
+			&collection.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		collections = append(collections, &collection)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return collections, metadata, nil
+}
+
+// GetMovies returns the movies of a collection in order. Movies in the trash keep their place but aren't shown
+func (m CollectionModel) GetMovies(ctx context.Context, collectionID int) ([]*CollectionMovie, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Read)
+	defer cancel()
+
+	return getCollectionMovies(ctx, m.DB, collectionID)
+}
+
+// queryer is what *sql.DB and *Tx have in common
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*Rows, error)
+}
+
+func getCollectionMovies(ctx context.Context, db queryer, collectionID int) ([]*CollectionMovie, error) {
+	query := `
+		SELECT row_number() OVER (ORDER BY collection_movies.position), movies.id, movies.title, movies.year
+		FROM collection_movies
+		INNER JOIN movies ON movies.id = collection_movies.movie_id
+		WHERE collection_movies.collection_id = $1 AND movies.deleted_at IS NULL
+		ORDER BY collection_movies.position`
+
+	rows, err := db.QueryContext(ctx, query, collectionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := []*CollectionMovie{}
+	for rows.Next() {
+		var movie CollectionMovie
+		err := rows.Scan(&movie.Position, &movie.MovieID, &movie.Title, &movie.Year)
+		if err != nil {
+			return nil, err
+		}
+		movies = append(movies, &movie)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+// change runs fn in a transaction after bumping the collection's version. The version check also locks
+// the collection row, so concurrent changes to the same collection's movies are serialized
+func (m CollectionModel) change(ctx context.Context, collection *Collection, fn func(ctx context.Context, tx *Tx) error) error {
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Write)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE collections
+		SET version = version + 1
+		WHERE id = $1 AND version = $2
+		RETURNING version`
+
+	var version int
+	err = tx.QueryRowContext(ctx, query, collection.ID, collection.Version).Scan(&version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = fn(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	collection.Version = version
+	return nil
+}
+
+// AddMovie inserts a movie at position, pushing the ones after it down. A position of 0 or past the end appends.
+// Positions are the ones GetMovies shows, which skip the movies in the trash, the new movie goes right
+// before the one shown at position
+func (m CollectionModel) AddMovie(ctx context.Context, collection *Collection, movieID int, position int) error {
+	return m.change(ctx, collection, func(ctx context.Context, tx *Tx) error {
+		query := `
+			WITH shown AS (
+				SELECT collection_movies.position, row_number() OVER (ORDER BY collection_movies.position) AS shown_position
+				FROM collection_movies
+				INNER JOIN movies ON movies.id = collection_movies.movie_id
+				WHERE collection_movies.collection_id = $1 AND movies.deleted_at IS NULL
+			), target AS (
+				SELECT coalesce(
+					(SELECT position FROM shown WHERE shown_position = $3),
+					(SELECT coalesce(max(position), 0) + 1 FROM collection_movies WHERE collection_id = $1)
+				) AS position
+			), shifted AS (
+				UPDATE collection_movies
+				SET position = position + 1
+				WHERE collection_id = $1 AND position >= (SELECT position FROM target)
+			)
+			INSERT INTO collection_movies (collection_id, movie_id, position)
+			SELECT $1, $2, position FROM target`
+
+		_, err := tx.ExecContext(ctx, query, collection.ID, movieID, position)
+		if err != nil {
+			switch {
+			case strings.HasPrefix(err.Error(), `pq: duplicate key value violates unique constraint "collection_movies_pkey"`):
+				return ErrDuplicateCollectionMovie
+			case strings.HasPrefix(err.Error(), `pq: insert or update on table "collection_movies" violates foreign key constraint`):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RemoveMovie takes a movie out of the collection and closes the gap it leaves
+func (m CollectionModel) RemoveMovie(ctx context.Context, collection *Collection, movieID int) error {
+	return m.change(ctx, collection, func(ctx context.Context, tx *Tx) error {
+		var position int
+		err := tx.QueryRowContext(ctx, `
+			DELETE FROM collection_movies
+			WHERE collection_id = $1 AND movie_id = $2
+			RETURNING position`, collection.ID, movieID).Scan(&position)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE collection_movies
+			SET position = position - 1
+			WHERE collection_id = $1 AND position > $2`, collection.ID, position)
+		return err
+	})
+}
+
+// Reorder puts the movies of the collection in the given order. movieIDs must hold exactly the movies
+// GetMovies returns, movies in the trash are moved after them keeping their relative order
+func (m CollectionModel) Reorder(ctx context.Context, collection *Collection, movieIDs []int) error {
+	return m.change(ctx, collection, func(ctx context.Context, tx *Tx) error {
+		current, err := getCollectionMovies(ctx, tx, collection.ID)
+		if err != nil {
+			return err
+		}
+
+		currentIDs := make([]int, 0, len(current))
+		for _, movie := range current {
+			currentIDs = append(currentIDs, movie.MovieID)
+		}
+
+		sorted := slices.Clone(movieIDs)
+		slices.Sort(sorted)
+		slices.Sort(currentIDs)
+		if !slices.Equal(sorted, currentIDs) {
+			return ErrCollectionMismatch
+		}
+
+		query := `
+			WITH ordered AS (
+				SELECT collection_movies.movie_id,
+				       row_number() OVER (ORDER BY requested.position NULLS LAST, collection_movies.position) AS position
+				FROM collection_movies
+				LEFT JOIN unnest($2::bigint[]) WITH ORDINALITY AS requested(movie_id, position)
+				       ON requested.movie_id = collection_movies.movie_id
+				WHERE collection_movies.collection_id = $1
+			)
+			UPDATE collection_movies
+			SET position = ordered.position
+			FROM ordered
+			WHERE collection_movies.collection_id = $1 AND collection_movies.movie_id = ordered.movie_id`
+
+		_, err = tx.ExecContext(ctx, query, collection.ID, pq.Array(movieIDs))
+		return err
+	})
+}
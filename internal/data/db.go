@@ -0,0 +1,220 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrQueryCanceled is returned (wrapping the driver's error) when a query was cut short because its context
+// was canceled, e.g. the client went away or the server is shutting down. Running out of time isn't a
+// cancellation, those errors come back as they are
+var ErrQueryCanceled = errors.New("query canceled")
+
+// ErrNoDatabase is returned by the models that only exist in postgres when there's no database behind
+// them, which is the case for the ones NewMemoryModels doesn't keep in memory
+var ErrNoDatabase = errors.New("no database, the model is only available with postgres")
+
+// Timeouts caps how long each kind of query can run, on top of whatever deadline the caller's context has
+type Timeouts struct {
+	Read   time.Duration // lookups by id and other small queries
+	Write  time.Duration // inserts, updates and deletes
+	Search time.Duration // paged listings, title search, recommendations
+	Bulk   time.Duration // merges and the trash purge
+}
+
+var DefaultTimeouts = Timeouts{
+	Read:   3 * time.Second,
+	Write:  3 * time.Second,
+	Search: 3 * time.Second,
+	Bulk:   30 * time.Second,
+}
+
+// DB is the database the models share. It's a *sql.DB whose queries report ErrQueryCanceled, together
+// with the timeouts the models run their queries with. Without a *sql.DB every query fails with ErrNoDatabase
+type DB struct {
+	*sql.DB
+	Timeouts Timeouts
+
+	tx *Tx // set on the DB of the models inside a unit of work, every query then runs in its transaction
+}
+
+// canceled wraps err in ErrQueryCanceled when the query failed because ctx was canceled. The driver has
+// its own way of saying so (pq answers "canceling statement due to user request"), only ctx knows why
+func canceled(ctx context.Context, err error) error {
+	if err == nil || errors.Is(err, sql.ErrNoRows) || !errors.Is(ctx.Err(), context.Canceled) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrQueryCanceled, err)
+}
+
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...any) *Row {
+	if db.tx != nil {
+		return db.tx.QueryRowContext(ctx, query, args...)
+	}
+	if db.DB == nil {
+		return &Row{err: ErrNoDatabase, ctx: ctx}
+	}
+	return &Row{row: db.DB.QueryRowContext(ctx, query, args...), ctx: ctx}
+}
+
+func (db *DB) QueryContext(ctx context.Context, query string, args ...any) (*Rows, error) {
+	if db.tx != nil {
+		return db.tx.QueryContext(ctx, query, args...)
+	}
+	if db.DB == nil {
+		return nil, ErrNoDatabase
+	}
+
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, canceled(ctx, err)
+	}
+	return &Rows{Rows: rows, ctx: ctx}, nil
+}
+
+func (db *DB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	if db.tx != nil {
+		return db.tx.ExecContext(ctx, query, args...)
+	}
+	if db.DB == nil {
+		return nil, ErrNoDatabase
+	}
+
+	result, err := db.DB.ExecContext(ctx, query, args...)
+	return result, canceled(ctx, err)
+}
+
+// BeginTx starts a transaction, inside a unit of work it's a savepoint of the unit's transaction instead
+// (opts don't apply then) so that it can still be rolled back on its own
+func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	if db.tx != nil {
+		return db.tx.nested(ctx)
+	}
+	if db.DB == nil {
+		return nil, ErrNoDatabase
+	}
+
+	tx, err := db.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, canceled(ctx, err)
+	}
+	return &Tx{Tx: tx, ctx: ctx}, nil
+}
+
+// unitOfWork runs fn with models whose queries all go through one transaction, see Models.InTx
+func (db *DB) unitOfWork(ctx context.Context, fn func(Models) error) error {
+	// already in one, fn becomes part of it
+	if db.tx != nil {
+		return fn(newModels(db))
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = fn(newModels(&DB{DB: db.DB, Timeouts: db.Timeouts, tx: tx}))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Tx is a *sql.Tx whose queries report ErrQueryCanceled like the ones of DB
+type Tx struct {
+	*sql.Tx
+	ctx context.Context // the one the transaction was started with, it also ends it when canceled
+
+	// set when the Tx is a savepoint nested in another transaction, Commit and Rollback only end the savepoint
+	savepoint string
+	done      bool
+}
+
+// savepointName can be the same for all of them, savepoints are always released or rolled back
+// newest first and postgres then goes for the latest one with the name
+const savepointName = "nested"
+
+func (tx *Tx) nested(ctx context.Context) (*Tx, error) {
+	_, err := tx.ExecContext(ctx, "SAVEPOINT "+savepointName)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx.Tx, ctx: ctx, savepoint: savepointName}, nil
+}
+
+// endSavepoint runs the statement that ends the savepoint, like with Commit and Rollback a second call
+// gets sql.ErrTxDone
+func (tx *Tx) endSavepoint(statement string) error {
+	if tx.done {
+		return sql.ErrTxDone
+	}
+	tx.done = true
+
+	_, err := tx.ExecContext(tx.ctx, statement)
+	return err
+}
+
+func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...any) *Row {
+	return &Row{row: tx.Tx.QueryRowContext(ctx, query, args...), ctx: ctx}
+}
+
+func (tx *Tx) QueryContext(ctx context.Context, query string, args ...any) (*Rows, error) {
+	rows, err := tx.Tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, canceled(ctx, err)
+	}
+	return &Rows{Rows: rows, ctx: ctx}, nil
+}
+
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	result, err := tx.Tx.ExecContext(ctx, query, args...)
+	return result, canceled(ctx, err)
+}
+
+func (tx *Tx) Commit() error {
+	if tx.savepoint != "" {
+		return tx.endSavepoint("RELEASE SAVEPOINT " + tx.savepoint)
+	}
+	return canceled(tx.ctx, tx.Tx.Commit())
+}
+
+func (tx *Tx) Rollback() error {
+	if tx.savepoint != "" {
+		// rolling back to a savepoint keeps it around, it's released too
+		return tx.endSavepoint("ROLLBACK TO SAVEPOINT " + tx.savepoint + "; RELEASE SAVEPOINT " + tx.savepoint)
+	}
+	return tx.Tx.Rollback()
+}
+
+// Row is the *sql.Row of DB.QueryRowContext, the error comes out of Scan
+type Row struct {
+	row *sql.Row
+	err error // set instead of row when the query couldn't run at all
+	ctx context.Context
+}
+
+func (r *Row) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	return canceled(r.ctx, r.row.Scan(dest...))
+}
+
+// Rows is a *sql.Rows, Next stops early when the context is canceled and Err tells why
+type Rows struct {
+	*sql.Rows
+	ctx context.Context
+}
+
+func (r *Rows) Scan(dest ...any) error {
+	return canceled(r.ctx, r.Rows.Scan(dest...))
+}
+
+func (r *Rows) Err() error {
+	return canceled(r.ctx, r.Rows.Err())
+}
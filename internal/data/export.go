@@ -0,0 +1,73 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// ExportBatchSize is how many rows are fetched from the cursor at a time
+const ExportBatchSize = 500
+
+// Export calls fn for every movie matching q, in the requested order. Rows are read from a server side
+// cursor one batch at a time so memory use stays the same no matter how big the catalog is.
+// The movie passed to fn is reused between calls, fn must not keep it around. None of the query timeouts
+// apply, an export takes as long as ctx allows
+func (m MovieModel) Export(ctx context.Context, q MovieQuery, fn func(*Movie) error) error {
+	// cursors only live inside a transaction
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var movie Movie
+	query, args, dest := q.build(&movie, false)
+
+	_, err = tx.ExecContext(ctx, "DECLARE movies_export NO SCROLL CURSOR FOR "+query, args...)
+	if err != nil {
+		return err
+	}
+
+	fetch := fmt.Sprintf("FETCH FORWARD %d FROM movies_export", ExportBatchSize)
+
+	for {
+		n, err := fetchBatch(ctx, tx, fetch, func(rows *Rows) error {
+			movie = Movie{}
+			err := rows.Scan(dest...)
+			if err != nil {
+				return err
+			}
+			return fn(&movie)
+		})
+		if err != nil {
+			return err
+		}
+
+		if n < ExportBatchSize {
+			break
+		}
+	}
+
+	return tx.Commit()
+}
+
+// fetchBatch runs a single FETCH and calls each for every row, it returns how many rows the cursor gave back
+func fetchBatch(ctx context.Context, tx *Tx, fetch string, each func(*Rows) error) (int, error) {
+	rows, err := tx.QueryContext(ctx, fetch)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		n++
+		err = each(rows)
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, rows.Err()
+}
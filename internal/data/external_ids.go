@@ -0,0 +1,278 @@
+package data
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"greenlight/internal/validator"
+
+	"github.com/lib/pq"
+)
+
+var ErrDuplicateExternalID = errors.New("duplicate external id")
+
+// ExternalIDs maps a source (see ExternalIDSources) to the id the movie has there
+type ExternalIDs map[string]string
+
+// ExternalIDSources are the sources a movie can have an id for, with the format of their ids.
+// Each one has a unique index in the database, adding a source needs a migration
+var ExternalIDSources = map[string]*regexp.Regexp{
+	"imdb": regexp.MustCompile(`^tt\d{7,10}$`),
+	"tmdb": regexp.MustCompile(`^\d{1,10}$`),
+}
+
+func ValidateExternalIDs(v *validator.Validator, ids ExternalIDs) {
+	for source, id := range ids {
+		format, ok := ExternalIDSources[source]
+		if !ok {
+			v.AddError("external_ids", "unknown source "+source)
+			continue
+		}
+		v.Check(format.MatchString(id), "external_ids", "invalid "+source+" id "+id)
+	}
+}
+
+func (ids ExternalIDs) Value() (driver.Value, error) {
+	if ids == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(map[string]string(ids))
+}
+
+func (ids *ExternalIDs) Scan(src any) error {
+	js, ok := src.([]byte)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into ExternalIDs", src)
+	}
+
+	*ids = nil
+	err := json.Unmarshal(js, (*map[string]string)(ids))
+	if err != nil {
+		return err
+	}
+
+	// no ids reads back as nil, so it's left out of the json
+	if len(*ids) == 0 {
+		*ids = nil
+	}
+	return nil
+}
+
+// externalIDClashes returns the positions of the movies with an external id that one of existing has,
+// or an earlier one of movies
+func externalIDClashes(movies []*Movie, existing []ExternalIDs) []int {
+	seen := make(map[string]bool)
+	for _, ids := range existing {
+		for source, id := range ids {
+			seen[source+":"+id] = true
+		}
+	}
+
+	var clashes []int
+	for n, movie := range movies {
+		clash := false
+		for source, id := range movie.ExternalIDs {
+			clash = clash || seen[source+":"+id]
+		}
+		if clash {
+			clashes = append(clashes, n)
+			continue
+		}
+
+		for source, id := range movie.ExternalIDs {
+			seen[source+":"+id] = true
+		}
+	}
+	return clashes
+}
+
+// isDuplicateExternalID reports whether err comes from one of the per source unique indexes
+func isDuplicateExternalID(err error) bool {
+	for source := range ExternalIDSources {
+		if strings.HasPrefix(err.Error(), fmt.Sprintf(`pq: duplicate key value violates unique constraint "movies_%s_id_idx"`, source)) {
+			return true
+		}
+	}
+	return false
+}
+
+// DuplicateCandidate is a pair of movies from the same year with similar titles
+type DuplicateCandidate struct {
+	Year       int     `json:"year"`
+	Similarity float64 `json:"similarity"`
+	Movies     [2]struct {
+		ID    int    `json:"id"`
+		Title string `json:"title"`
+	} `json:"movies"`
+}
+
+// FindDuplicates pairs up movies of the same year whose titles are at least minSimilarity alike
+// (pg_trgm similarity, from 0 to 1), the most similar first
+func (m MovieModel) FindDuplicates(ctx context.Context, minSimilarity float64, filters Filters) ([]*DuplicateCandidate, Metadata, error) {
+	// wrapped so the sort keys (and the id tiebreak) refer to the pair
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), year, similarity, id, title, other_id, other_title
+		FROM (
+			SELECT a.year, similarity(a.title, b.title) AS similarity,
+			       a.id, a.title, b.id AS other_id, b.title AS other_title
+			FROM movies AS a
+			INNER JOIN movies AS b ON b.year = a.year AND b.id > a.id
+			WHERE a.deleted_at IS NULL AND b.deleted_at IS NULL
+			AND   similarity(a.title, b.title) >= $1
+		) AS candidates
+		ORDER BY %s
+		LIMIT $2 OFFSET $3`, filters.orderBy())
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Search)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, minSimilarity, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	candidates := []*DuplicateCandidate{}
+	for rows.Next() {
+		var candidate DuplicateCandidate
+		err := rows.Scan(
+			&totalRecords,
+			&candidate.Year,
+			&candidate.Similarity,
+			&candidate.Movies[0].ID,
+			&candidate.Movies[0].Title,
+			&candidate.Movies[1].ID,
+			&candidate.Movies[1].Title,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		candidates = append(candidates, &candidate)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return candidates, metadata, nil
+}
+
+// mergeReferences moves what points at the duplicate over to the target. Rows that would clash with one
+// the target already has (the same user's review, the same credit...) stay behind and go away with the duplicate
+var mergeReferences = []string{
+	`UPDATE movie_credits SET movie_id = $1
+	 WHERE movie_id = $2 AND NOT EXISTS (
+	     SELECT 1 FROM movie_credits AS t
+	     WHERE t.movie_id = $1 AND t.person_id = movie_credits.person_id AND t.role = movie_credits.role)`,
+	`UPDATE reviews SET movie_id = $1
+	 WHERE movie_id = $2 AND NOT EXISTS (
+	     SELECT 1 FROM reviews AS t WHERE t.movie_id = $1 AND t.user_id = reviews.user_id)`,
+	`UPDATE watchlist SET movie_id = $1
+	 WHERE movie_id = $2 AND NOT EXISTS (
+	     SELECT 1 FROM watchlist AS t WHERE t.movie_id = $1 AND t.user_id = watchlist.user_id)`,
+	`UPDATE watched SET movie_id = $1 WHERE movie_id = $2`,
+	`UPDATE collection_movies SET movie_id = $1
+	 WHERE movie_id = $2 AND NOT EXISTS (
+	     SELECT 1 FROM collection_movies AS t WHERE t.movie_id = $1 AND t.collection_id = collection_movies.collection_id)`,
+	`UPDATE movie_titles SET movie_id = $1
+	 WHERE movie_id = $2 AND NOT EXISTS (
+	     SELECT 1 FROM movie_titles AS t WHERE t.movie_id = $1 AND t.language = movie_titles.language)`,
+	`UPDATE movie_revisions SET movie_id = $1, merged_from = coalesce(merged_from, $2) WHERE movie_id = $2`,
+}
+
+// Merge folds duplicate into target and deletes it for good. The caller combines the fields beforehand:
+// target is saved as it is passed (as a new revision), and both versions must still match the database.
+// Its rating and votes are read back, they count the reviews moved over from the duplicate.
+// It returns the storage keys of the images the merged movie doesn't keep, of either movie
+func (m MovieModel) Merge(ctx context.Context, target *Movie, duplicate *Movie, userID int) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Bulk)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// both rows are locked in id order, so two merges of the same pair can't deadlock
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, version, images FROM movies
+		WHERE id = ANY($1) AND deleted_at IS NULL
+		ORDER BY id
+		FOR UPDATE`, pq.Array([]int{target.ID, duplicate.ID}))
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make(map[int]int)
+	var images []*MovieImages
+	for rows.Next() {
+		var id, version int
+		var movieImages *MovieImages
+		err = rows.Scan(&id, &version, &movieImages)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		versions[id] = version
+		images = append(images, movieImages)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if versions[target.ID] != target.Version || versions[duplicate.ID] != duplicate.Version {
+		return nil, ErrEditConflict
+	}
+
+	for _, query := range mergeReferences {
+		_, err = tx.ExecContext(ctx, query, target.ID, duplicate.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the duplicate goes first, its external ids would clash with the target's otherwise
+	_, err = tx.ExecContext(ctx, `DELETE FROM movies WHERE id = $1`, duplicate.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		WITH updated AS (
+			UPDATE movies
+			SET genres = $1, external_ids = $2, images = $3, version = version + 1
+			WHERE id = $4
+			RETURNING id, created_at, title, year, runtime, genres, rating, votes, version
+		), ` + revisionCTE("updated", "$5") + `
+		SELECT version, rating, votes FROM updated`
+
+	var targetImages any
+	if target.Images != nil {
+		targetImages = *target.Images
+	}
+
+	err = tx.QueryRowContext(ctx, query, pq.Array(target.Genres), target.ExternalIDs, targetImages, target.ID, nullID(userID)).Scan(&target.Version, &target.Rating, &target.Votes)
+	if err != nil {
+		switch {
+		case isDuplicateExternalID(err):
+			return nil, ErrDuplicateExternalID
+		default:
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return unusedKeys(images, target.Images), nil
+}
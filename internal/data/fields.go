@@ -0,0 +1,121 @@
+package data
+
+import (
+	"strings"
+
+	"greenlight/internal/validator"
+
+	"github.com/lib/pq"
+)
+
+// MovieFieldSafeList holds the fields a client can ask for with ?fields=
+var MovieFieldSafeList = []string{"id", "title", "year", "runtime", "genres", "rating", "votes", "images", "external_ids", "version"}
+
+func ValidateFields(v *validator.Validator, fields []string, safeList []string) {
+	for _, field := range fields {
+		v.Check(validator.PermittedValue(field, safeList...), "fields", "invalid field "+field)
+	}
+	v.Check(validator.UniqueValues(fields), "fields", "must not contain duplicate values")
+}
+
+// movieColumns returns the columns to select for the requested fields and where each one is scanned into.
+// An empty fields list means the whole movie. Only names from MovieFieldSafeList ever reach the query
+func movieColumns(movie *Movie, fields []string) (string, []any) {
+	if len(fields) == 0 {
+		return "id, created_at, title, year, runtime, genres, rating, votes, images, external_ids, version",
+			[]any{&movie.ID, &movie.CreatedAt, &movie.Title, &movie.Year, &movie.Runtime, pq.Array(&movie.Genres), &movie.Rating, &movie.Votes, &movie.Images, &movie.ExternalIDs, &movie.Version}
+	}
+
+	columns := []string{}
+	dest := []any{}
+
+	for _, field := range fields {
+		switch field {
+		case "id":
+			dest = append(dest, &movie.ID)
+		case "title":
+			dest = append(dest, &movie.Title)
+		case "year":
+			dest = append(dest, &movie.Year)
+		case "runtime":
+			dest = append(dest, &movie.Runtime)
+		case "genres":
+			dest = append(dest, pq.Array(&movie.Genres))
+		case "rating":
+			dest = append(dest, &movie.Rating)
+		case "votes":
+			dest = append(dest, &movie.Votes)
+		case "images":
+			dest = append(dest, &movie.Images)
+		case "external_ids":
+			dest = append(dest, &movie.ExternalIDs)
+		case "version":
+			dest = append(dest, &movie.Version)
+		default:
+			panic("unsafe movie field " + field)
+		}
+		columns = append(columns, field)
+	}
+
+	return strings.Join(columns, ", "), dest
+}
+
+// Project returns only the requested fields of a movie, ready to be encoded.
+// Extras (relevance, highlighted title, credits) are kept whenever they were computed
+func (movie *Movie) Project(fields []string) map[string]any {
+	projection := make(map[string]any, len(fields))
+
+	for _, field := range fields {
+		switch field {
+		case "id":
+			projection[field] = movie.ID
+		case "title":
+			projection[field] = movie.Title
+			if movie.OriginalTitle != "" {
+				projection["original_title"] = movie.OriginalTitle
+				projection["title_language"] = movie.TitleLanguage
+			}
+		case "year":
+			projection[field] = movie.Year
+		case "runtime":
+			projection[field] = movie.Runtime.jsonValue(movie.RuntimeFormat)
+		case "genres":
+			projection[field] = movie.Genres
+		case "rating":
+			projection[field] = movie.Rating
+		case "votes":
+			projection[field] = movie.Votes
+		case "images":
+			projection[field] = movie.Images
+		case "external_ids":
+			projection[field] = movie.ExternalIDs
+		case "version":
+			projection[field] = movie.Version
+		}
+	}
+
+	if movie.Relevance != 0 {
+		projection["relevance"] = movie.Relevance
+	}
+	if movie.HighlightedTitle != "" {
+		projection["highlighted_title"] = movie.HighlightedTitle
+	}
+	if movie.Credits != nil {
+		projection["credits"] = movie.Credits
+	}
+
+	return projection
+}
+
+// ProjectMovies applies Project to a whole listing, with no fields the movies are returned untouched
+func ProjectMovies(movies []*Movie, fields []string) any {
+	if len(fields) == 0 {
+		return movies
+	}
+
+	projected := make([]map[string]any, 0, len(movies))
+	for _, movie := range movies {
+		projected = append(projected, movie.Project(fields))
+	}
+	return projected
+}
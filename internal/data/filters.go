@@ -28,26 +28,69 @@ func ValidateFilters(v *validator.Validator, filters *Filters) {
 	v.Check(filters.PageSize > 0, "page_size", "must be greater than zero")
 	v.Check(filters.PageSize <= 100, "page_size", "must be less than 100")
 
-	v.Check(validator.PermittedValue(filters.Sort, filters.SortSafeList...), "sort", "invalid sort value")
+	ValidateSort(v, filters)
 }
 
-// extract the collums name form the sort parameter
-func (f Filters) sortCollumn() string {
-	if slices.Contains(f.SortSafeList, f.Sort) {
-		return strings.TrimPrefix(f.Sort, "-")
+// ValidateSort only checks the sort keys, for listings that aren't paged
+func ValidateSort(v *validator.Validator, filters *Filters) {
+	// each key is checked on its own and a column can only appear once, so "year,-year" is rejected
+	seen := make(map[string]bool)
+	for _, key := range filters.sortKeys() {
+		v.Check(validator.PermittedValue(key, filters.SortSafeList...), "sort", "invalid sort value")
+
+		column := strings.TrimPrefix(key, "-")
+		v.Check(!seen[column], "sort", "must not contain repeated or conflicting keys")
+		seen[column] = true
+	}
+}
+
+// sortKeys splits a sort parameter like "-year,title" into its keys
+func (f Filters) sortKeys() []string {
+	return strings.Split(f.Sort, ",")
+}
+
+// extract the collums name form a sort key
+func (f Filters) sortCollumn(key string) string {
+	if slices.Contains(f.SortSafeList, key) {
+		return strings.TrimPrefix(key, "-")
 	}
-	panic("unsafe sort parameter" + f.Sort)
+	panic("unsafe sort parameter" + key)
 }
 
-func (f Filters) sortDirection() string {
+func (f Filters) sortDirection(key string) string {
+	// relevance is a score, the natural order is best match first
+	if key == "relevance" {
+		return "DESC"
+	}
 
-	if strings.HasPrefix(f.Sort, "-") {
+	if strings.HasPrefix(key, "-") {
 		return "DESC"
 	}
 	return "ASC"
 
 }
 
+// orderBy builds the ORDER BY list, id is appended as a tiebreak (unless already sorted on)
+// so the same query always returns rows in the same order across pages
+func (f Filters) orderBy() string {
+	terms := []string{}
+	tiebreak := true
+
+	for _, key := range f.sortKeys() {
+		column := f.sortCollumn(key)
+		if column == "id" {
+			tiebreak = false
+		}
+		terms = append(terms, column+" "+f.sortDirection(key))
+	}
+
+	if tiebreak {
+		terms = append(terms, "id ASC")
+	}
+
+	return strings.Join(terms, ", ")
+}
+
 func (f Filters) limit() int {
 	return f.PageSize
 }
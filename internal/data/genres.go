@@ -0,0 +1,134 @@
+package data
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"greenlight/internal/validator"
+
+	"github.com/lib/pq"
+)
+
+// Genre is an entry of the genre taxonomy, movies store the slug
+type Genre struct {
+	Slug    string   `json:"slug"`
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases,omitempty"`
+	Movies  int      `json:"movies"`
+}
+
+type GenreModel struct {
+	DB *DB
+}
+
+// GenreResolver maps every accepted spelling of a genre (see genreKeys) to its slug
+type GenreResolver map[string]string
+
+var nonSlugChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// genreKeys returns the forms a genre is looked up under: lowercased with single spaces ("science fiction"),
+// and as a slug ("science-fiction"). The genres migration checks both forms the same way, a genre is only
+// new when neither of them is an alias
+func genreKeys(genre string) []string {
+	name := strings.ToLower(strings.Join(strings.Fields(genre), " "))
+	slug := strings.Trim(nonSlugChars.ReplaceAllString(name, "-"), "-")
+	return []string{name, slug}
+}
+
+// Normalize returns the canonical slugs of genres, in the same order. Unknown genres are added to v, and so
+// are the ones whose two forms are aliases of different genres
+func (resolver GenreResolver) Normalize(v *validator.Validator, genres []string) []string {
+	if genres == nil {
+		return nil
+	}
+
+	normalized := make([]string, 0, len(genres))
+	for _, genre := range genres {
+		slug, ambiguous := "", false
+		for _, key := range genreKeys(genre) {
+			if s, ok := resolver[key]; ok {
+				ambiguous = ambiguous || (slug != "" && s != slug)
+				slug = s
+			}
+		}
+
+		v.Check(slug != "", "genres", "unknown genre "+genre)
+		v.Check(!ambiguous, "genres", "ambiguous genre "+genre)
+		if slug != "" && !ambiguous {
+			normalized = append(normalized, slug)
+		}
+	}
+
+	return normalized
+}
+
+// Resolver loads every alias, the taxonomy is small enough to keep in memory for a request
+func (m GenreModel) Resolver(ctx context.Context) (GenreResolver, error) {
+	query := `
+		SELECT genre_aliases.alias, genres.slug
+		FROM genre_aliases
+		INNER JOIN genres ON genres.id = genre_aliases.genre_id`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Read)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	resolver := make(GenreResolver)
+	for rows.Next() {
+		var alias, slug string
+		err := rows.Scan(&alias, &slug)
+		if err != nil {
+			return nil, err
+		}
+		resolver[alias] = slug
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return resolver, nil
+}
+
+// GetAll lists every genre with its aliases and how many movies (not counting the trash) have it
+func (m GenreModel) GetAll(ctx context.Context) ([]*Genre, error) {
+	query := `
+		SELECT genres.slug, genres.name,
+		       ARRAY(
+		           SELECT alias FROM genre_aliases
+		           WHERE genre_id = genres.id AND alias NOT IN (genres.slug, lower(genres.name))
+		           ORDER BY alias
+		       ),
+		       (SELECT count(*) FROM movies WHERE genres @> ARRAY[genres.slug] AND deleted_at IS NULL)
+		FROM genres
+		ORDER BY genres.name`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Read)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	genres := []*Genre{}
+	for rows.Next() {
+		var genre Genre
+		err := rows.Scan(&genre.Slug, &genre.Name, pq.Array(&genre.Aliases), &genre.Movies)
+		if err != nil {
+			return nil, err
+		}
+		genres = append(genres, &genre)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return genres, nil
+}
@@ -0,0 +1,52 @@
+package data
+
+import (
+	"slices"
+	"testing"
+
+	"greenlight/internal/validator"
+)
+
+func TestGenreResolverNormalize(t *testing.T) {
+	resolver := GenreResolver{
+		"science-fiction": "science-fiction",
+		"science fiction": "science-fiction",
+		"sci-fi":          "science-fiction",
+		"film-noir":       "film-noir",
+		"film noir":       "film-noir",
+		// a genre made from a movie's "Sci Fi" while "sci-fi" was already an alias
+		"sci fi": "sci-fi-2",
+	}
+
+	tests := []struct {
+		genre string
+		want  string // empty when the genre must be reported
+	}{
+		{"science-fiction", "science-fiction"},
+		{"Science   Fiction", "science-fiction"},
+		{"SCI-FI", "science-fiction"},
+		// only the slug form is an alias
+		{"Film-Noir!", "film-noir"},
+		{"Sci_Fi", "science-fiction"},
+		// the two forms lead to different genres
+		{"Sci Fi", ""},
+		{"space opera", ""},
+	}
+
+	for _, tt := range tests {
+		v := validator.New()
+		got := resolver.Normalize(v, []string{tt.genre})
+
+		switch {
+		case tt.want == "" && v.Valid():
+			t.Errorf("%q: got %q, want it reported", tt.genre, got)
+		case tt.want != "" && (!v.Valid() || !slices.Equal(got, []string{tt.want})):
+			t.Errorf("%q: got %q (errors %v), want %q", tt.genre, got, v.Errors, tt.want)
+		}
+	}
+
+	v := validator.New()
+	if got := resolver.Normalize(v, nil); got != nil || !v.Valid() {
+		t.Errorf("got %q for no genres, want nil", got)
+	}
+}
@@ -0,0 +1,137 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Image is a stored file, Key is where the storage backend keeps it and is never sent to clients
+type Image struct {
+	Key    string `json:"-"`
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// MovieImages is the poster of a movie and its thumbnails keyed by size name
+type MovieImages struct {
+	Poster     Image            `json:"poster"`
+	Thumbnails map[string]Image `json:"thumbnails"`
+}
+
+// storedImage is Image as saved in the database, with its key
+type storedImage struct {
+	Key    string `json:"key"`
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+type storedImages struct {
+	Poster     storedImage            `json:"poster"`
+	Thumbnails map[string]storedImage `json:"thumbnails"`
+}
+
+// Value stores the images as jsonb, keys included
+func (images MovieImages) Value() (driver.Value, error) {
+	stored := storedImages{
+		Poster:     storedImage(images.Poster),
+		Thumbnails: make(map[string]storedImage, len(images.Thumbnails)),
+	}
+	for size, image := range images.Thumbnails {
+		stored.Thumbnails[size] = storedImage(image)
+	}
+
+	return json.Marshal(stored)
+}
+
+func (images *MovieImages) Scan(src any) error {
+	js, ok := src.([]byte)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into MovieImages", src)
+	}
+
+	var stored storedImages
+	err := json.Unmarshal(js, &stored)
+	if err != nil {
+		return err
+	}
+
+	images.Poster = Image(stored.Poster)
+	images.Thumbnails = make(map[string]Image, len(stored.Thumbnails))
+	for size, image := range stored.Thumbnails {
+		images.Thumbnails[size] = Image(image)
+	}
+	return nil
+}
+
+// Keys lists every stored file, so they can all be deleted once the images are replaced
+func (images *MovieImages) Keys() []string {
+	if images == nil {
+		return nil
+	}
+
+	keys := []string{images.Poster.Key}
+	for _, image := range images.Thumbnails {
+		keys = append(keys, image.Key)
+	}
+	return keys
+}
+
+// unusedKeys lists the files of before that after doesn't use anymore
+func unusedKeys(before []*MovieImages, after *MovieImages) []string {
+	kept := make(map[string]bool)
+	for _, key := range after.Keys() {
+		kept[key] = true
+	}
+
+	keys := []string{}
+	for _, images := range before {
+		for _, key := range images.Keys() {
+			if !kept[key] {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+// SetImages replaces the images of a movie, nil removes them. It returns the previous ones so their
+// files can be cleaned up. Images aren't part of the revision history so the version isn't bumped
+func (m MovieModel) SetImages(ctx context.Context, id int, images *MovieImages) (*MovieImages, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		UPDATE movies
+		SET images = $2
+		FROM (SELECT id, images FROM movies WHERE id = $1 FOR UPDATE) AS previous
+		WHERE movies.id = previous.id AND movies.deleted_at IS NULL
+		RETURNING previous.images`
+
+	var value any
+	if images != nil {
+		value = *images
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Write)
+	defer cancel()
+
+	var previous *MovieImages
+	err := m.DB.QueryRowContext(ctx, query, id, value).Scan(&previous)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return previous, nil
+}
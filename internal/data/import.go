@@ -0,0 +1,160 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// ImportBatchSize is how many movies go in a single INSERT, 5 params each keeps us far from postgres' 65535 limit
+const ImportBatchSize = 500
+
+// MovieImport inserts movies in batches, all of them inside a single transaction
+// so a database error part way through leaves nothing behind
+type MovieImport struct {
+	tx     *Tx
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewImport starts the transaction. None of the query timeouts apply, imports get a longer deadline
+// than regular queries so the transaction lasts as long as ctx does
+func (m MovieModel) NewImport(ctx context.Context) (MovieImporter, error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return &MovieImport{tx: tx, ctx: ctx, cancel: cancel}, nil
+}
+
+// InsertBatch inserts the movies (and their first revisions) with one multi row INSERT and fills in
+// their id, created_at and version. userID is who ran the import. Movies with an external id that another
+// movie already has, or an earlier one of the import, are left out: taken holds their positions in movies
+func (i *MovieImport) InsertBatch(movies []*Movie, userID int) (taken []int, err error) {
+	if len(movies) == 0 {
+		return nil, nil
+	}
+
+	taken, err = i.takenExternalIDs(movies)
+	if err != nil {
+		return nil, err
+	}
+
+	inserting := make([]*Movie, 0, len(movies))
+	values := make([]string, 0, len(movies))
+	args := make([]any, 0, len(movies)*5)
+
+	for n, movie := range movies {
+		if slices.Contains(taken, n) {
+			continue
+		}
+		inserting = append(inserting, movie)
+
+		p := len(args)
+		values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d)", p+1, p+2, p+3, p+4, p+5))
+		args = append(args, movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.ExternalIDs)
+	}
+	if len(inserting) == 0 {
+		return taken, nil
+	}
+
+	args = append(args, nullID(userID))
+
+	query := `
+		WITH inserted AS (
+			INSERT INTO movies (title, year, runtime, genres, external_ids)
+			VALUES ` + strings.Join(values, ", ") + `
+			RETURNING id, created_at, title, year, runtime, genres, version
+		), ` + revisionCTE("inserted", fmt.Sprintf("$%d", len(args))) + `
+		SELECT id, created_at, version FROM inserted`
+
+	rows, err := i.tx.QueryContext(i.ctx, query, args...)
+	if err != nil {
+		// a movie with one of the ids was added since they were checked
+		if isDuplicateExternalID(err) {
+			return nil, ErrDuplicateExternalID
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	// rows come back in the same order as the VALUES list
+	for _, movie := range inserting {
+		if !rows.Next() {
+			break
+		}
+		err := rows.Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return taken, rows.Err()
+}
+
+// takenExternalIDs returns the positions of the movies with an external id that's in the database already,
+// movies of earlier batches included, or that an earlier movie of the batch has
+func (i *MovieImport) takenExternalIDs(movies []*Movie) ([]int, error) {
+	ids := make(map[string][]string)
+	for _, movie := range movies {
+		for source, id := range movie.ExternalIDs {
+			ids[source] = append(ids[source], id)
+		}
+	}
+
+	// one condition per source, each one served by the source's unique index. Only the known sources
+	// are looked at, the names go in the query
+	conditions := []string{}
+	args := []any{}
+	for source := range ExternalIDSources {
+		sourceIDs, ok := ids[source]
+		if !ok {
+			continue
+		}
+		args = append(args, pq.Array(sourceIDs))
+		conditions = append(conditions, fmt.Sprintf(`(external_ids ? '%[1]s' AND external_ids->>'%[1]s' = ANY($%[2]d))`, source, len(args)))
+	}
+
+	if len(conditions) == 0 {
+		return nil, nil
+	}
+
+	rows, err := i.tx.QueryContext(i.ctx, `SELECT external_ids FROM movies WHERE `+strings.Join(conditions, " OR "), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	existing := []ExternalIDs{}
+	for rows.Next() {
+		var movieIDs ExternalIDs
+		err := rows.Scan(&movieIDs)
+		if err != nil {
+			return nil, err
+		}
+		existing = append(existing, movieIDs)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return externalIDClashes(movies, existing), nil
+}
+
+func (i *MovieImport) Commit() error {
+	defer i.cancel()
+	return i.tx.Commit()
+}
+
+// Rollback is safe to defer, after a Commit it does nothing
+func (i *MovieImport) Rollback() error {
+	defer i.cancel()
+	return i.tx.Rollback()
+}
@@ -0,0 +1,434 @@
+package data
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// memory holds the data of the in-memory stores, they share it the way the postgres models share the database
+type memory struct {
+	mu sync.Mutex
+
+	movies    map[int]*Movie
+	revisions []*memoryRevision
+	users     map[int]*User
+	tokens    []*Token
+	perms     map[int]Permissions
+	emails    []*memoryEmail
+
+	// last ids handed out, like the identity columns they stand in for
+	lastMovieID    int
+	lastRevisionID int
+	lastUserID     int
+	lastEmailID    int
+
+	// set on the copy a unit of work runs on, InTx on its models joins the unit
+	inUnit bool
+}
+
+type memoryRevision struct {
+	id int
+	MovieRevision
+}
+
+// memoryPermissionCodes are the codes the migrations seed the permissions table with
+var memoryPermissionCodes = []string{"movies:read", "movies:write", "collections:admin", "outbox:admin"}
+
+// NewMemoryModels returns models whose movies, users, tokens, permissions and outbox live in memory, for running
+// the handlers without postgres. Genres are the seeded taxonomy and there are no localized titles, people,
+// reviews, watchlists or collections: those models fail with ErrNoDatabase
+func NewMemoryModels() Models {
+	db := &memory{
+		movies: make(map[int]*Movie),
+		users:  make(map[int]*User),
+		perms:  make(map[int]Permissions),
+	}
+	return db.models()
+}
+
+func (db *memory) models() Models {
+	return Models{
+		Movies:      memoryMovies{db},
+		Users:       memoryUsers{db},
+		Tokens:      memoryTokens{db},
+		Permissions: memoryPermissions{db},
+		Outbox:      memoryOutbox{db},
+		People:      PeopleModel{DB: noDB},
+		Reviews:     ReviewModel{DB: noDB},
+		Watchlist:   WatchlistModel{DB: noDB},
+		Collections: CollectionModel{DB: noDB},
+		Genres:      memoryGenres{db},
+		Titles:      memoryTitles{db},
+		backend:     db,
+	}
+}
+
+// noDB stands in for the database of the models there's no memory version of
+var noDB = &DB{Timeouts: DefaultTimeouts}
+
+// unitOfWork runs fn on a copy of the data and keeps the copy only when fn succeeds. The store stays
+// locked meanwhile, so units of work (and everything else) are serialized: fn must only use the models
+// it's given, the ones outside would wait for it to return
+func (db *memory) unitOfWork(ctx context.Context, fn func(Models) error) error {
+	// already in one, fn works on the same copy like it would in the same transaction
+	if db.inUnit {
+		if err := ctx.Err(); err != nil {
+			return canceled(ctx, err)
+		}
+		return fn(db.models())
+	}
+
+	if err := db.lock(ctx); err != nil {
+		return err
+	}
+	defer db.mu.Unlock()
+
+	work := db.copy()
+	work.inUnit = true
+
+	err := fn(work.models())
+	if err != nil {
+		return err
+	}
+
+	db.movies, db.revisions, db.users, db.tokens, db.perms = work.movies, work.revisions, work.users, work.tokens, work.perms
+	db.emails = work.emails
+	db.lastMovieID, db.lastRevisionID, db.lastUserID, db.lastEmailID = work.lastMovieID, work.lastRevisionID, work.lastUserID, work.lastEmailID
+	return nil
+}
+
+// copy is a deep copy of the data, changing one doesn't change the other
+func (db *memory) copy() *memory {
+	c := &memory{
+		movies:         make(map[int]*Movie, len(db.movies)),
+		revisions:      make([]*memoryRevision, 0, len(db.revisions)),
+		users:          make(map[int]*User, len(db.users)),
+		tokens:         make([]*Token, 0, len(db.tokens)),
+		perms:          make(map[int]Permissions, len(db.perms)),
+		emails:         make([]*memoryEmail, 0, len(db.emails)),
+		lastMovieID:    db.lastMovieID,
+		lastRevisionID: db.lastRevisionID,
+		lastUserID:     db.lastUserID,
+		lastEmailID:    db.lastEmailID,
+	}
+
+	for id, movie := range db.movies {
+		c.movies[id] = cloneMovie(movie)
+	}
+	for _, revision := range db.revisions {
+		c.revisions = append(c.revisions, &memoryRevision{id: revision.id, MovieRevision: *cloneRevision(&revision.MovieRevision)})
+	}
+	for id, user := range db.users {
+		c.users[id] = cloneUser(user)
+	}
+	for _, token := range db.tokens {
+		clone := *token
+		c.tokens = append(c.tokens, &clone)
+	}
+	for id, perms := range db.perms {
+		c.perms[id] = slices.Clone(perms)
+	}
+	for _, email := range db.emails {
+		clone := *email
+		c.emails = append(c.emails, &clone)
+	}
+
+	return c
+}
+
+// lock is where every memory store method starts, a canceled ctx fails the same way a canceled query does
+func (db *memory) lock(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return canceled(ctx, err)
+	}
+	db.mu.Lock()
+	return nil
+}
+
+type memoryUsers struct {
+	db *memory
+}
+
+func (s memoryUsers) Insert(ctx context.Context, user *User) error {
+	if err := s.db.lock(ctx); err != nil {
+		return err
+	}
+	defer s.db.mu.Unlock()
+
+	if s.db.userByEmail(user.Email) != nil {
+		return ErrDuplicateEmail
+	}
+
+	s.db.lastUserID++
+	user.ID = s.db.lastUserID
+	user.CreatedAt = time.Now().Truncate(time.Second)
+	user.Version = 1
+
+	s.db.users[user.ID] = cloneUser(user)
+	return nil
+}
+
+func (s memoryUsers) GetByEmail(ctx context.Context, email string) (*User, error) {
+	if err := s.db.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.db.mu.Unlock()
+
+	user := s.db.userByEmail(email)
+	if user == nil {
+		return nil, ErrRecordNotFound
+	}
+	return cloneUser(user), nil
+}
+
+func (s memoryUsers) Update(ctx context.Context, user *User) error {
+	if err := s.db.lock(ctx); err != nil {
+		return err
+	}
+	defer s.db.mu.Unlock()
+
+	stored, ok := s.db.users[user.ID]
+	if !ok || stored.Version != user.Version {
+		return ErrEditConflict
+	}
+
+	if other := s.db.userByEmail(user.Email); other != nil && other.ID != user.ID {
+		return ErrDuplicateEmail
+	}
+
+	user.Version++
+	s.db.users[user.ID] = cloneUser(user)
+	return nil
+}
+
+func (s memoryUsers) GetForToken(ctx context.Context, scope string, tokenPlainText string) (*User, error) {
+	if err := s.db.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.db.mu.Unlock()
+
+	tokenHash := sha256.Sum256([]byte(tokenPlainText))
+	now := time.Now()
+
+	for _, token := range s.db.tokens {
+		if bytes.Equal(token.Hash, tokenHash[:]) && token.Scope == scope && token.Expiry.After(now) {
+			if user, ok := s.db.users[token.UserID]; ok {
+				return cloneUser(user), nil
+			}
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+// userByEmail compares emails ignoring case, like the citext column does
+func (db *memory) userByEmail(email string) *User {
+	for _, user := range db.users {
+		if strings.EqualFold(user.Email, email) {
+			return user
+		}
+	}
+	return nil
+}
+
+func cloneUser(user *User) *User {
+	clone := *user
+	clone.Password.plaintext = nil
+	clone.Password.hash = slices.Clone(user.Password.hash)
+	return &clone
+}
+
+type memoryTokens struct {
+	db *memory
+}
+
+func (s memoryTokens) New(ctx context.Context, userID int, ttl time.Duration, scope string) (*Token, error) {
+	token := generateToken(userID, ttl, scope)
+
+	err := s.Insert(ctx, token)
+	return token, err
+}
+
+func (s memoryTokens) Insert(ctx context.Context, token *Token) error {
+	if err := s.db.lock(ctx); err != nil {
+		return err
+	}
+	defer s.db.mu.Unlock()
+
+	stored := *token
+	stored.Plaintext = ""
+	stored.Hash = slices.Clone(token.Hash)
+
+	s.db.tokens = append(s.db.tokens, &stored)
+	return nil
+}
+
+func (s memoryTokens) DeleteAllForUser(ctx context.Context, scope string, userID int) error {
+	if err := s.db.lock(ctx); err != nil {
+		return err
+	}
+	defer s.db.mu.Unlock()
+
+	s.db.tokens = slices.DeleteFunc(s.db.tokens, func(token *Token) bool {
+		return token.UserID == userID && token.Scope == scope
+	})
+	return nil
+}
+
+type memoryPermissions struct {
+	db *memory
+}
+
+// AddForUser skips codes the user already has, and like the postgres model the ones that don't exist
+func (s memoryPermissions) AddForUser(ctx context.Context, userID int, codes ...string) error {
+	if err := s.db.lock(ctx); err != nil {
+		return err
+	}
+	defer s.db.mu.Unlock()
+
+	for _, code := range codes {
+		if slices.Contains(memoryPermissionCodes, code) && !s.db.perms[userID].Includes(code) {
+			s.db.perms[userID] = append(s.db.perms[userID], code)
+		}
+	}
+	return nil
+}
+
+func (s memoryPermissions) GetAllForUser(ctx context.Context, userID int) (Permissions, error) {
+	if err := s.db.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.db.mu.Unlock()
+
+	return slices.Clone(s.db.perms[userID]), nil
+}
+
+// sortByFilters sorts items the way Filters.orderBy sorts rows, value returns the column of an item
+func sortByFilters[T any](items []T, filters Filters, value func(item T, column string) any) {
+	slices.SortStableFunc(items, func(a, b T) int {
+		tiebreak := true
+
+		for _, key := range filters.sortKeys() {
+			column := filters.sortCollumn(key)
+			if column == "id" {
+				tiebreak = false
+			}
+
+			c := compareValues(value(a, column), value(b, column))
+			if filters.sortDirection(key) == "DESC" {
+				c = -c
+			}
+			if c != 0 {
+				return c
+			}
+		}
+
+		if tiebreak {
+			return compareValues(value(a, "id"), value(b, "id"))
+		}
+		return 0
+	})
+}
+
+func compareValues(a, b any) int {
+	switch a := a.(type) {
+	case int:
+		return cmp.Compare(a, b.(int))
+	case float64:
+		return cmp.Compare(a, b.(float64))
+	case string:
+		return strings.Compare(a, b.(string))
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	}
+	panic(fmt.Sprintf("cannot compare values of type %T", a))
+}
+
+// page returns the page of items filters asks for, with its metadata
+func page[T any](items []T, filters Filters) ([]T, Metadata) {
+	metadata := calculateMetadata(len(items), filters.Page, filters.PageSize)
+
+	start := min(filters.offset(), len(items))
+	end := min(start+filters.limit(), len(items))
+
+	return items[start:end], metadata
+}
+
+// trigrams splits s the way pg_trgm does: lower cased words padded with two spaces in front and one behind
+func trigrams(s string) map[string]bool {
+	set := make(map[string]bool)
+
+	for _, word := range titleWords(s) {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			set[string(padded[i:i+3])] = true
+		}
+	}
+	return set
+}
+
+// similarity is pg_trgm's similarity(): the share of trigrams the two strings have in common
+func similarity(a, b string) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0
+	}
+
+	shared := 0
+	for trigram := range ta {
+		if tb[trigram] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(ta)+len(tb)-shared)
+}
+
+// titleWords splits a title into lower cased words, anything that isn't a letter or a digit separates them
+func titleWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// cloneMovie copies the columns of a movie, leaving the extras (relevance, credits...) behind
+func cloneMovie(movie *Movie) *Movie {
+	clone := &Movie{
+		ID:        movie.ID,
+		CreatedAt: movie.CreatedAt,
+		Title:     movie.Title,
+		Year:      movie.Year,
+		Runtime:   movie.Runtime,
+		Genres:    slices.Clone(movie.Genres),
+		Rating:    movie.Rating,
+		Votes:     movie.Votes,
+		Version:   movie.Version,
+		DeletedAt: movie.DeletedAt,
+	}
+
+	// no ids reads back as nil from the database too
+	if len(movie.ExternalIDs) > 0 {
+		clone.ExternalIDs = maps.Clone(movie.ExternalIDs)
+	}
+
+	clone.Images = cloneImages(movie.Images)
+	return clone
+}
+
+func cloneImages(images *MovieImages) *MovieImages {
+	if images == nil {
+		return nil
+	}
+
+	clone := *images
+	clone.Thumbnails = maps.Clone(images.Thumbnails)
+	return &clone
+}
@@ -0,0 +1,75 @@
+package data
+
+import (
+	"context"
+	"slices"
+	"strings"
+)
+
+// memoryGenreTaxonomy is the taxonomy the genres migration seeds, sorted by name like GenreModel.GetAll
+var memoryGenreTaxonomy = []Genre{
+	{Slug: "action", Name: "Action"},
+	{Slug: "adventure", Name: "Adventure"},
+	{Slug: "animation", Name: "Animation", Aliases: []string{"animated"}},
+	{Slug: "biography", Name: "Biography", Aliases: []string{"biopic"}},
+	{Slug: "comedy", Name: "Comedy", Aliases: []string{"comedies"}},
+	{Slug: "crime", Name: "Crime"},
+	{Slug: "documentary", Name: "Documentary", Aliases: []string{"docs", "documentaries"}},
+	{Slug: "drama", Name: "Drama"},
+	{Slug: "family", Name: "Family"},
+	{Slug: "fantasy", Name: "Fantasy"},
+	{Slug: "history", Name: "History", Aliases: []string{"historical"}},
+	{Slug: "horror", Name: "Horror"},
+	{Slug: "music", Name: "Music"},
+	{Slug: "musical", Name: "Musical"},
+	{Slug: "mystery", Name: "Mystery"},
+	{Slug: "romance", Name: "Romance", Aliases: []string{"romantic"}},
+	{Slug: "science-fiction", Name: "Science Fiction", Aliases: []string{"sci-fi", "scifi", "sf"}},
+	{Slug: "sport", Name: "Sport", Aliases: []string{"sports"}},
+	{Slug: "thriller", Name: "Thriller"},
+	{Slug: "war", Name: "War"},
+	{Slug: "western", Name: "Western"},
+}
+
+// memoryGenres is the GenreStore of NewMemoryModels, the taxonomy is fixed
+type memoryGenres struct {
+	db *memory
+}
+
+func (s memoryGenres) Resolver(ctx context.Context) (GenreResolver, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, canceled(ctx, err)
+	}
+
+	// the slug and the lowercased name are aliases too, like in genre_aliases
+	resolver := make(GenreResolver)
+	for _, genre := range memoryGenreTaxonomy {
+		resolver[genre.Slug] = genre.Slug
+		resolver[strings.ToLower(genre.Name)] = genre.Slug
+		for _, alias := range genre.Aliases {
+			resolver[alias] = genre.Slug
+		}
+	}
+
+	return resolver, nil
+}
+
+func (s memoryGenres) GetAll(ctx context.Context) ([]*Genre, error) {
+	if err := s.db.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.db.mu.Unlock()
+
+	genres := make([]*Genre, 0, len(memoryGenreTaxonomy))
+	for _, genre := range memoryGenreTaxonomy {
+		genre.Aliases = slices.Clone(genre.Aliases)
+		for _, movie := range s.db.movies {
+			if movie.DeletedAt.IsZero() && slices.Contains(movie.Genres, genre.Slug) {
+				genre.Movies++
+			}
+		}
+		genres = append(genres, &genre)
+	}
+
+	return genres, nil
+}
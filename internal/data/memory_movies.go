@@ -0,0 +1,771 @@
+package data
+
+import (
+	"context"
+	"html"
+	"math"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// memoryMovies is the MovieStore of NewMemoryModels. There are no people, reviews or localized titles in
+// memory, so no movie has credits, ratings or other titles, and title search is an approximation of the
+// postgres one: words are matched as they are, without stemming or stop words
+type memoryMovies struct {
+	db *memory
+}
+
+func (s memoryMovies) Insert(ctx context.Context, movie *Movie, userID int) error {
+	if err := s.db.lock(ctx); err != nil {
+		return err
+	}
+	defer s.db.mu.Unlock()
+
+	if s.db.externalIDTaken(movie.ExternalIDs) {
+		return ErrDuplicateExternalID
+	}
+
+	s.db.lastMovieID++
+	movie.ID = s.db.lastMovieID
+	movie.CreatedAt = time.Now().Truncate(time.Second)
+	movie.Version = 1
+
+	stored := cloneMovie(movie)
+	stored.Rating, stored.Votes, stored.Images = 0, 0, nil
+
+	s.db.movies[stored.ID] = stored
+	s.db.addRevision(stored, userID)
+	return nil
+}
+
+func (s memoryMovies) Get(ctx context.Context, id int) (*Movie, error) {
+	return s.GetFields(ctx, id, nil)
+}
+
+func (s memoryMovies) GetFields(ctx context.Context, id int, fields []string) (*Movie, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	if err := s.db.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.db.mu.Unlock()
+
+	stored := s.db.activeMovie(id)
+	if stored == nil {
+		return nil, ErrRecordNotFound
+	}
+	return pickFields(cloneMovie(stored), fields), nil
+}
+
+func (s memoryMovies) Update(ctx context.Context, movie *Movie, userID int) error {
+	if err := s.db.lock(ctx); err != nil {
+		return err
+	}
+	defer s.db.mu.Unlock()
+
+	stored := s.db.activeMovie(movie.ID)
+	if stored == nil || stored.Version != movie.Version {
+		return ErrEditConflict
+	}
+
+	if s.db.externalIDTaken(movie.ExternalIDs, movie.ID) {
+		return ErrDuplicateExternalID
+	}
+
+	updated := cloneMovie(movie)
+	stored.Title = updated.Title
+	stored.Year = updated.Year
+	stored.Runtime = updated.Runtime
+	stored.Genres = updated.Genres
+	stored.ExternalIDs = updated.ExternalIDs
+	stored.Version++
+
+	movie.Version = stored.Version
+	s.db.addRevision(stored, userID)
+	return nil
+}
+
+func (s memoryMovies) Delete(ctx context.Context, id int) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	if err := s.db.lock(ctx); err != nil {
+		return err
+	}
+	defer s.db.mu.Unlock()
+
+	stored := s.db.activeMovie(id)
+	if stored == nil {
+		return ErrRecordNotFound
+	}
+
+	stored.DeletedAt = time.Now()
+	return nil
+}
+
+func (s memoryMovies) GetAll(ctx context.Context, q MovieQuery) ([]*Movie, Metadata, error) {
+	if err := s.db.lock(ctx); err != nil {
+		return nil, Metadata{}, err
+	}
+	defer s.db.mu.Unlock()
+
+	movies, metadata := page(s.db.query(q), q.Filters)
+	return movies, metadata, nil
+}
+
+// Export works on a copy of the matching movies, the store isn't locked while fn runs
+func (s memoryMovies) Export(ctx context.Context, q MovieQuery, fn func(*Movie) error) error {
+	if err := s.db.lock(ctx); err != nil {
+		return err
+	}
+	movies := s.db.query(q)
+	s.db.mu.Unlock()
+
+	for _, movie := range movies {
+		if err := ctx.Err(); err != nil {
+			return canceled(ctx, err)
+		}
+
+		err := fn(movie)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// query is MovieQuery.build for the movies in memory, every matching movie in the requested order
+func (db *memory) query(q MovieQuery) []*Movie {
+	movies := []*Movie{}
+
+	// nobody is credited on anything
+	if q.PersonID > 0 {
+		return movies
+	}
+
+	for _, stored := range db.movies {
+		if !stored.DeletedAt.IsZero() || !containsAll(stored.Genres, q.Genres) {
+			continue
+		}
+
+		movie := cloneMovie(stored)
+
+		if q.Title != "" {
+			matched, ok := matchTitle(q.Search, q.Title, movie.Title)
+			if !ok {
+				continue
+			}
+
+			movie.Relevance = relevance(matched)
+			if q.Highlight != nil {
+				movie.HighlightedTitle = highlightTitle(movie.Title, matched, *q.Highlight)
+			}
+		}
+
+		movies = append(movies, movie)
+	}
+
+	// sorted before the fields are picked, the sort columns don't have to be among them
+	sortByFilters(movies, q.Filters, movieColumn)
+
+	for i, movie := range movies {
+		movies[i] = pickFields(movie, q.Fields)
+	}
+
+	return movies
+}
+
+// matchTitle reports which words of the title match the search, every word searched for has to match one.
+// Full text search needs the exact words, fuzzy search also takes prefixes and words with a typo
+func matchTitle(mode SearchMode, search string, title string) ([]bool, bool) {
+	words := titleWords(title)
+	searched := titleWords(search)
+	if len(searched) == 0 {
+		return nil, false
+	}
+
+	matched := make([]bool, len(words))
+	for _, s := range searched {
+		found := false
+		for i, word := range words {
+			if word == s || mode == SearchFuzzy && (strings.HasPrefix(word, s) || similarity(word, s) >= 0.6) {
+				matched[i] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+
+	return matched, true
+}
+
+// relevance is the share of the title's words that matched
+func relevance(matched []bool) float64 {
+	n := 0
+	for _, m := range matched {
+		if m {
+			n++
+		}
+	}
+	return float64(n) / float64(len(matched))
+}
+
+// highlightTitle wraps the matched words of the escaped title in the highlight tags, the way ts_headline does
+func highlightTitle(title string, matched []bool, h Highlight) string {
+	var b strings.Builder
+
+	word := -1
+	inWord := false
+
+	for _, r := range title {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+
+		switch {
+		case isWord && !inWord:
+			word++
+			if matched[word] {
+				b.WriteString(h.StartSel)
+			}
+		case !isWord && inWord && matched[word]:
+			b.WriteString(h.StopSel)
+		}
+
+		inWord = isWord
+		b.WriteString(html.EscapeString(string(r)))
+	}
+
+	if inWord && matched[word] {
+		b.WriteString(h.StopSel)
+	}
+
+	return b.String()
+}
+
+// pickFields is movieColumns for a movie in memory, with fields only those are copied over
+func pickFields(movie *Movie, fields []string) *Movie {
+	if len(fields) == 0 {
+		return movie
+	}
+
+	picked := &Movie{Relevance: movie.Relevance, HighlightedTitle: movie.HighlightedTitle, Score: movie.Score}
+
+	for _, field := range fields {
+		switch field {
+		case "id":
+			picked.ID = movie.ID
+		case "title":
+			picked.Title = movie.Title
+		case "year":
+			picked.Year = movie.Year
+		case "runtime":
+			picked.Runtime = movie.Runtime
+		case "genres":
+			picked.Genres = movie.Genres
+		case "rating":
+			picked.Rating = movie.Rating
+		case "votes":
+			picked.Votes = movie.Votes
+		case "images":
+			picked.Images = movie.Images
+		case "external_ids":
+			picked.ExternalIDs = movie.ExternalIDs
+		case "version":
+			picked.Version = movie.Version
+		default:
+			panic("unsafe movie field " + field)
+		}
+	}
+
+	return picked
+}
+
+// movieColumn is the value a movie is sorted on for a column
+func movieColumn(movie *Movie, column string) any {
+	switch column {
+	case "id":
+		return movie.ID
+	case "title":
+		return movie.Title
+	case "year":
+		return movie.Year
+	case "runtime":
+		return int(movie.Runtime)
+	case "rating":
+		return movie.Rating
+	case "votes":
+		return movie.Votes
+	case "relevance":
+		return movie.Relevance
+	case "score":
+		return movie.Score
+	case "created_at":
+		return movie.CreatedAt
+	case "deleted_at":
+		return movie.DeletedAt
+	}
+	panic("unsafe sort column " + column)
+}
+
+func containsAll(values []string, wanted []string) bool {
+	for _, w := range wanted {
+		if !slices.Contains(values, w) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s memoryMovies) Suggest(ctx context.Context, q string, limit int) ([]*MovieSuggestion, error) {
+	if err := s.db.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.db.mu.Unlock()
+
+	q = strings.TrimSpace(q)
+	lower := strings.ToLower(q)
+
+	type candidate struct {
+		*MovieSuggestion
+		prefix     bool
+		similarity float64
+	}
+
+	candidates := []candidate{}
+	for _, movie := range s.db.movies {
+		title := strings.ToLower(movie.Title)
+		if !movie.DeletedAt.IsZero() || !strings.Contains(title, lower) {
+			continue
+		}
+
+		candidates = append(candidates, candidate{
+			MovieSuggestion: &MovieSuggestion{ID: movie.ID, Title: movie.Title, Year: movie.Year},
+			prefix:          strings.HasPrefix(title, lower),
+			similarity:      similarity(movie.Title, q),
+		})
+	}
+
+	slices.SortFunc(candidates, func(a, b candidate) int {
+		switch {
+		case a.prefix != b.prefix:
+			if a.prefix {
+				return -1
+			}
+			return 1
+		case a.similarity != b.similarity:
+			return compareValues(b.similarity, a.similarity)
+		case a.Title != b.Title:
+			return strings.Compare(a.Title, b.Title)
+		}
+		return compareValues(a.ID, b.ID)
+	})
+
+	suggestions := []*MovieSuggestion{}
+	for _, c := range candidates[:min(limit, len(candidates))] {
+		suggestions = append(suggestions, c.MovieSuggestion)
+	}
+
+	return suggestions, nil
+}
+
+// GetSimilar scores the movies like MovieModel.GetSimilar does, minus the fans in common (there are no reviews)
+func (s memoryMovies) GetSimilar(ctx context.Context, id int, filters Filters) ([]*Movie, Metadata, error) {
+	if err := s.db.lock(ctx); err != nil {
+		return nil, Metadata{}, err
+	}
+	defer s.db.mu.Unlock()
+
+	movies := []*Movie{}
+
+	source := s.db.activeMovie(id)
+	if source == nil {
+		return movies, Metadata{}, nil
+	}
+
+	for _, other := range s.db.movies {
+		if other.ID == source.ID || !other.DeletedAt.IsZero() {
+			continue
+		}
+
+		shared := 0
+		for _, genre := range other.Genres {
+			if slices.Contains(source.Genres, genre) {
+				shared++
+			}
+		}
+		titles := similarity(other.Title, source.Title)
+
+		// the % operator of pg_trgm, with its default threshold
+		if shared == 0 && titles < 0.3 {
+			continue
+		}
+
+		union := len(other.Genres) + len(source.Genres) - shared
+
+		movie := cloneMovie(other)
+		movie.Score = SimilarWeights.Genres*float64(shared)/float64(max(union, 1)) +
+			SimilarWeights.Year/(1+math.Abs(float64(other.Year-source.Year))/10) +
+			SimilarWeights.Title*titles
+
+		movies = append(movies, movie)
+	}
+
+	sortByFilters(movies, filters, movieColumn)
+
+	movies, metadata := page(movies, filters)
+	return movies, metadata, nil
+}
+
+func (s memoryMovies) GetTrash(ctx context.Context, filters Filters) ([]*Movie, Metadata, error) {
+	if err := s.db.lock(ctx); err != nil {
+		return nil, Metadata{}, err
+	}
+	defer s.db.mu.Unlock()
+
+	movies := []*Movie{}
+	for _, stored := range s.db.movies {
+		if !stored.DeletedAt.IsZero() {
+			movies = append(movies, cloneMovie(stored))
+		}
+	}
+
+	sortByFilters(movies, filters, movieColumn)
+
+	movies, metadata := page(movies, filters)
+	return movies, metadata, nil
+}
+
+func (s memoryMovies) Restore(ctx context.Context, id int, userID int) (*Movie, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	if err := s.db.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.db.mu.Unlock()
+
+	stored, ok := s.db.movies[id]
+	if !ok || stored.DeletedAt.IsZero() {
+		return nil, ErrRecordNotFound
+	}
+
+	stored.DeletedAt = time.Time{}
+	stored.Version++
+	s.db.addRevision(stored, userID)
+
+	return cloneMovie(stored), nil
+}
+
+func (s memoryMovies) Purge(ctx context.Context, retention time.Duration) (int64, []string, error) {
+	if err := s.db.lock(ctx); err != nil {
+		return 0, nil, err
+	}
+	defer s.db.mu.Unlock()
+
+	cutoff := time.Now().Add(-retention)
+
+	var purged int64
+	var images []*MovieImages
+	for id, stored := range s.db.movies {
+		if !stored.DeletedAt.IsZero() && stored.DeletedAt.Before(cutoff) {
+			images = append(images, stored.Images)
+			s.db.deleteMovie(id)
+			purged++
+		}
+	}
+
+	return purged, unusedKeys(images, nil), nil
+}
+
+func (s memoryMovies) GetRevisions(ctx context.Context, movieID int, filters Filters) ([]*MovieRevision, Metadata, error) {
+	if err := s.db.lock(ctx); err != nil {
+		return nil, Metadata{}, err
+	}
+	defer s.db.mu.Unlock()
+
+	matching := []*memoryRevision{}
+	for _, revision := range s.db.revisions {
+		if revision.MovieID == movieID {
+			matching = append(matching, revision)
+		}
+	}
+
+	sortByFilters(matching, filters, func(revision *memoryRevision, column string) any {
+		switch column {
+		case "id":
+			return revision.id
+		case "version":
+			return revision.Version
+		}
+		panic("unsafe sort column " + column)
+	})
+
+	matching, metadata := page(matching, filters)
+
+	revisions := []*MovieRevision{}
+	for _, revision := range matching {
+		revisions = append(revisions, cloneRevision(&revision.MovieRevision))
+	}
+
+	return revisions, metadata, nil
+}
+
+func (s memoryMovies) GetRevision(ctx context.Context, movieID int, version int) (*MovieRevision, error) {
+	if movieID < 1 || version < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	if err := s.db.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.db.mu.Unlock()
+
+	for _, revision := range s.db.revisions {
+		if revision.MovieID == movieID && revision.Version == version && revision.MergedFrom == 0 {
+			return cloneRevision(&revision.MovieRevision), nil
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+func (s memoryMovies) SetImages(ctx context.Context, id int, images *MovieImages) (*MovieImages, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	if err := s.db.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.db.mu.Unlock()
+
+	stored := s.db.activeMovie(id)
+	if stored == nil {
+		return nil, ErrRecordNotFound
+	}
+
+	previous := stored.Images
+	stored.Images = cloneImages(images)
+	return previous, nil
+}
+
+func (s memoryMovies) FindDuplicates(ctx context.Context, minSimilarity float64, filters Filters) ([]*DuplicateCandidate, Metadata, error) {
+	if err := s.db.lock(ctx); err != nil {
+		return nil, Metadata{}, err
+	}
+	defer s.db.mu.Unlock()
+
+	candidates := []*DuplicateCandidate{}
+	for _, a := range s.db.movies {
+		for _, b := range s.db.movies {
+			if b.ID <= a.ID || b.Year != a.Year || !a.DeletedAt.IsZero() || !b.DeletedAt.IsZero() {
+				continue
+			}
+
+			alike := similarity(a.Title, b.Title)
+			if alike < minSimilarity {
+				continue
+			}
+
+			candidate := &DuplicateCandidate{Year: a.Year, Similarity: alike}
+			candidate.Movies[0].ID, candidate.Movies[0].Title = a.ID, a.Title
+			candidate.Movies[1].ID, candidate.Movies[1].Title = b.ID, b.Title
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	sortByFilters(candidates, filters, func(candidate *DuplicateCandidate, column string) any {
+		switch column {
+		case "id":
+			return candidate.Movies[0].ID
+		case "year":
+			return candidate.Year
+		case "similarity":
+			return candidate.Similarity
+		}
+		panic("unsafe sort column " + column)
+	})
+
+	candidates, metadata := page(candidates, filters)
+	return candidates, metadata, nil
+}
+
+func (s memoryMovies) Merge(ctx context.Context, target *Movie, duplicate *Movie, userID int) ([]string, error) {
+	if err := s.db.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.db.mu.Unlock()
+
+	storedTarget := s.db.activeMovie(target.ID)
+	storedDuplicate := s.db.activeMovie(duplicate.ID)
+
+	if storedTarget == nil || storedDuplicate == nil ||
+		storedTarget.Version != target.Version || storedDuplicate.Version != duplicate.Version {
+		return nil, ErrEditConflict
+	}
+
+	if s.db.externalIDTaken(target.ExternalIDs, target.ID, duplicate.ID) {
+		return nil, ErrDuplicateExternalID
+	}
+
+	// the revisions are the only thing pointing at the duplicate that there is in memory
+	for _, revision := range s.db.revisions {
+		if revision.MovieID == duplicate.ID {
+			revision.MovieID = target.ID
+			if revision.MergedFrom == 0 {
+				revision.MergedFrom = duplicate.ID
+			}
+		}
+	}
+	delete(s.db.movies, duplicate.ID)
+
+	before := []*MovieImages{storedTarget.Images, storedDuplicate.Images}
+
+	merged := cloneMovie(target)
+	storedTarget.Genres = merged.Genres
+	storedTarget.ExternalIDs = merged.ExternalIDs
+	storedTarget.Images = merged.Images
+	storedTarget.Version++
+
+	target.Version, target.Rating, target.Votes = storedTarget.Version, storedTarget.Rating, storedTarget.Votes
+	s.db.addRevision(storedTarget, userID)
+	return unusedKeys(before, storedTarget.Images), nil
+}
+
+// memoryImport keeps the batches aside until Commit, like the transaction of MovieImport
+type memoryImport struct {
+	db      *memory
+	ctx     context.Context
+	pending []*Movie
+	userIDs []int
+	done    bool
+}
+
+func (s memoryMovies) NewImport(ctx context.Context) (MovieImporter, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, canceled(ctx, err)
+	}
+	return &memoryImport{db: s.db, ctx: ctx}, nil
+}
+
+func (i *memoryImport) InsertBatch(movies []*Movie, userID int) ([]int, error) {
+	if err := i.db.lock(i.ctx); err != nil {
+		return nil, err
+	}
+	defer i.db.mu.Unlock()
+
+	existing := make([]ExternalIDs, 0, len(i.db.movies)+len(i.pending))
+	for _, movie := range i.db.movies {
+		existing = append(existing, movie.ExternalIDs)
+	}
+	for _, movie := range i.pending {
+		existing = append(existing, movie.ExternalIDs)
+	}
+	taken := externalIDClashes(movies, existing)
+
+	for n, movie := range movies {
+		if slices.Contains(taken, n) {
+			continue
+		}
+
+		i.db.lastMovieID++
+		movie.ID = i.db.lastMovieID
+		movie.CreatedAt = time.Now().Truncate(time.Second)
+		movie.Version = 1
+
+		stored := cloneMovie(movie)
+		stored.Rating, stored.Votes, stored.Images = 0, 0, nil
+
+		i.pending = append(i.pending, stored)
+		i.userIDs = append(i.userIDs, userID)
+	}
+
+	return taken, nil
+}
+
+func (i *memoryImport) Commit() error {
+	if err := i.db.lock(i.ctx); err != nil {
+		return err
+	}
+	defer i.db.mu.Unlock()
+
+	for n, movie := range i.pending {
+		i.db.movies[movie.ID] = movie
+		i.db.addRevision(movie, i.userIDs[n])
+	}
+
+	i.pending, i.userIDs, i.done = nil, nil, true
+	return nil
+}
+
+func (i *memoryImport) Rollback() error {
+	if !i.done {
+		i.pending, i.userIDs, i.done = nil, nil, true
+	}
+	return nil
+}
+
+// activeMovie returns the stored movie with id, nil when there's none or it's in the trash
+func (db *memory) activeMovie(id int) *Movie {
+	movie, ok := db.movies[id]
+	if !ok || !movie.DeletedAt.IsZero() {
+		return nil
+	}
+	return movie
+}
+
+// externalIDTaken reports whether a movie other than the excluded ones already has one of ids.
+// Movies in the trash count, the unique indexes cover them too
+func (db *memory) externalIDTaken(ids ExternalIDs, exclude ...int) bool {
+	for _, movie := range db.movies {
+		if slices.Contains(exclude, movie.ID) {
+			continue
+		}
+		for source, id := range ids {
+			if movie.ExternalIDs[source] == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// addRevision stores the movie as it is now as a revision, like revisionCTE
+func (db *memory) addRevision(movie *Movie, userID int) {
+	db.lastRevisionID++
+	db.revisions = append(db.revisions, &memoryRevision{
+		id: db.lastRevisionID,
+		MovieRevision: MovieRevision{
+			MovieID:   movie.ID,
+			Version:   movie.Version,
+			CreatedAt: time.Now().Truncate(time.Second),
+			UserID:    userID,
+			Title:     movie.Title,
+			Year:      movie.Year,
+			Runtime:   movie.Runtime,
+			Genres:    slices.Clone(movie.Genres),
+		},
+	})
+}
+
+// deleteMovie removes a movie for good, its revisions go with it like with the foreign key's ON DELETE CASCADE
+func (db *memory) deleteMovie(id int) {
+	delete(db.movies, id)
+	db.revisions = slices.DeleteFunc(db.revisions, func(revision *memoryRevision) bool {
+		return revision.MovieID == id
+	})
+}
+
+func cloneRevision(revision *MovieRevision) *MovieRevision {
+	clone := *revision
+	clone.Genres = slices.Clone(revision.Genres)
+	return &clone
+}
@@ -0,0 +1,238 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// memoryEmail is an outbox email as the table has it, data is kept as json so it reads back like a jsonb column
+type memoryEmail struct {
+	OutboxEmail
+	data        []byte
+	lockedUntil time.Time
+}
+
+// outboxEmail returns a copy of the email for handing out
+func (email *memoryEmail) outboxEmail() (*OutboxEmail, error) {
+	clone := email.OutboxEmail
+	clone.LockedUntil = email.lockedUntil
+	err := decodeEmailData(email.data, &clone)
+	if err != nil {
+		return nil, err
+	}
+	return &clone, nil
+}
+
+func (email *memoryEmail) leased(now time.Time) bool {
+	return email.lockedUntil.After(now)
+}
+
+// claimedBy reports whether the email is still pending under the claim claimed came from, like the
+// locked_until match of MarkSent and MarkFailed
+func (email *memoryEmail) claimedBy(claimed *OutboxEmail) bool {
+	return email != nil && email.Status == OutboxPending && !email.lockedUntil.IsZero() && email.lockedUntil.Equal(claimed.LockedUntil)
+}
+
+type memoryOutbox struct {
+	db *memory
+}
+
+func (s memoryOutbox) Enqueue(ctx context.Context, email *OutboxEmail) error {
+	data, err := json.Marshal(email.Data)
+	if err != nil {
+		return err
+	}
+
+	if err := s.db.lock(ctx); err != nil {
+		return err
+	}
+	defer s.db.mu.Unlock()
+
+	for _, stored := range s.db.emails {
+		if stored.IdempotencyKey == email.IdempotencyKey {
+			return nil
+		}
+	}
+
+	s.db.lastEmailID++
+	email.ID = s.db.lastEmailID
+	email.CreatedAt = time.Now().Truncate(time.Second)
+	email.Status = OutboxPending
+	email.NextAttemptAt = time.Now()
+
+	stored := &memoryEmail{OutboxEmail: *email, data: data}
+	stored.Data, stored.Attempts, stored.LastError, stored.SentAt = nil, 0, "", time.Time{}
+
+	s.db.emails = append(s.db.emails, stored)
+	return nil
+}
+
+func (s memoryOutbox) Claim(ctx context.Context, limit int, lease time.Duration) ([]*OutboxEmail, error) {
+	if err := s.db.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.db.mu.Unlock()
+
+	now := time.Now()
+
+	due := []*memoryEmail{}
+	for _, stored := range s.db.emails {
+		if stored.Status == OutboxPending && !stored.NextAttemptAt.After(now) && !stored.leased(now) {
+			due = append(due, stored)
+		}
+	}
+
+	sortByFilters(due, Filters{Sort: "next_attempt_at", SortSafeList: []string{"next_attempt_at"}}, emailColumn)
+
+	emails := []*OutboxEmail{}
+	for _, stored := range due[:min(limit, len(due))] {
+		stored.Attempts++
+		stored.lockedUntil = now.Add(lease)
+
+		email, err := stored.outboxEmail()
+		if err != nil {
+			return nil, err
+		}
+		emails = append(emails, email)
+	}
+
+	return emails, nil
+}
+
+func (s memoryOutbox) MarkSent(ctx context.Context, email *OutboxEmail) error {
+	if err := s.db.lock(ctx); err != nil {
+		return err
+	}
+	defer s.db.mu.Unlock()
+
+	stored := s.db.email(email.ID)
+	if !stored.claimedBy(email) {
+		return ErrLeaseLost
+	}
+
+	stored.Status = OutboxSent
+	stored.SentAt = time.Now()
+	stored.LastError = ""
+	stored.data = []byte("{}")
+	stored.lockedUntil = time.Time{}
+
+	email.Status, email.SentAt, email.Data, email.LastError, email.LockedUntil = OutboxSent, stored.SentAt, nil, "", time.Time{}
+	return nil
+}
+
+func (s memoryOutbox) MarkFailed(ctx context.Context, email *OutboxEmail, sendErr error, retry OutboxRetry) error {
+	if err := s.db.lock(ctx); err != nil {
+		return err
+	}
+	defer s.db.mu.Unlock()
+
+	stored := s.db.email(email.ID)
+	if !stored.claimedBy(email) {
+		return ErrLeaseLost
+	}
+
+	status, next := failedAttempt(email, retry)
+
+	stored.Status = status
+	stored.NextAttemptAt = next
+	stored.LastError = sendErr.Error()
+	stored.lockedUntil = time.Time{}
+
+	email.Status, email.NextAttemptAt, email.LastError, email.LockedUntil = status, next, sendErr.Error(), time.Time{}
+	return nil
+}
+
+func (s memoryOutbox) Get(ctx context.Context, id int) (*OutboxEmail, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	if err := s.db.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.db.mu.Unlock()
+
+	stored := s.db.email(id)
+	if stored == nil {
+		return nil, ErrRecordNotFound
+	}
+	return stored.outboxEmail()
+}
+
+func (s memoryOutbox) GetAll(ctx context.Context, status string, filters Filters) ([]*OutboxEmail, Metadata, error) {
+	if err := s.db.lock(ctx); err != nil {
+		return nil, Metadata{}, err
+	}
+	defer s.db.mu.Unlock()
+
+	matching := []*memoryEmail{}
+	for _, stored := range s.db.emails {
+		if status == "" || stored.Status == status {
+			matching = append(matching, stored)
+		}
+	}
+
+	sortByFilters(matching, filters, emailColumn)
+
+	matching, metadata := page(matching, filters)
+
+	emails := []*OutboxEmail{}
+	for _, stored := range matching {
+		email, err := stored.outboxEmail()
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		emails = append(emails, email)
+	}
+
+	return emails, metadata, nil
+}
+
+func (s memoryOutbox) Retry(ctx context.Context, id int) (*OutboxEmail, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	if err := s.db.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.db.mu.Unlock()
+
+	stored := s.db.email(id)
+	switch {
+	case stored == nil:
+		return nil, ErrRecordNotFound
+	case stored.Status == OutboxSent:
+		return nil, ErrAlreadySent
+	case stored.leased(time.Now()):
+		return nil, ErrEditConflict
+	}
+
+	stored.Status = OutboxPending
+	stored.Attempts = 0
+	stored.NextAttemptAt = time.Now()
+
+	return stored.outboxEmail()
+}
+
+func (db *memory) email(id int) *memoryEmail {
+	for _, stored := range db.emails {
+		if stored.ID == id {
+			return stored
+		}
+	}
+	return nil
+}
+
+func emailColumn(email *memoryEmail, column string) any {
+	switch column {
+	case "id":
+		return email.ID
+	case "created_at":
+		return email.CreatedAt
+	case "next_attempt_at":
+		return email.NextAttemptAt
+	}
+	panic("unsafe sort column " + column)
+}
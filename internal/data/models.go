@@ -2,6 +2,7 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 )
@@ -14,19 +15,51 @@ var (
 // this struct is so that in future it's easier to add new models types to the app
 
 type Models struct {
+	Movies      MovieStore
+	Users       UserStore
+	Tokens      TokenStore
+	Permissions PermissionStore
+	Outbox      OutboxStore
+	People      PeopleModel
+	Reviews     ReviewModel
+	Watchlist   WatchlistModel
+	Collections CollectionModel
+	Genres      GenreStore
+	Titles      TitleStore
 
-	Movies      MovieModel
-	Users       UserModel
-	Tokens      TokenModel
-	Permissions PermissionsModel
+	backend unitOfWorker
+}
+
+// unitOfWorker is what the models are stored in, it knows how to make a set of changes atomic
+type unitOfWorker interface {
+	unitOfWork(ctx context.Context, fn func(Models) error) error
+}
 
+// NewModels builds the models on top of db, their queries are cut short after the given timeouts
+func NewModels(db *sql.DB, timeouts Timeouts) Models {
+	return newModels(&DB{DB: db, Timeouts: timeouts})
 }
 
-func NewModels(db *sql.DB) Models {
+func newModels(database *DB) Models {
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Users:       UserModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Permissions: PermissionsModel{DB: db},
+		Movies:      MovieModel{DB: database},
+		Users:       &UserModel{DB: database},
+		Tokens:      &TokenModel{DB: database},
+		Permissions: &PermissionsModel{DB: database},
+		Outbox:      OutboxModel{DB: database},
+		People:      PeopleModel{DB: database},
+		Reviews:     ReviewModel{DB: database},
+		Watchlist:   WatchlistModel{DB: database},
+		Collections: CollectionModel{DB: database},
+		Genres:      GenreModel{DB: database},
+		Titles:      TitleModel{DB: database},
+		backend:     database,
 	}
 }
+
+// InTx runs fn as a unit of work: everything done through the models fn is given either happens or, when
+// fn returns an error (or panics), doesn't. Calling InTx again inside fn joins the unit already running.
+// The models given to fn belong to it alone, they mustn't be used from other goroutines or after it returns
+func (m Models) InTx(ctx context.Context, fn func(Models) error) error {
+	return m.backend.unitOfWork(ctx, fn)
+}
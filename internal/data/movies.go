@@ -4,6 +4,7 @@ package data
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
@@ -15,17 +16,57 @@ import (
 
 // Movie - all fields with capital letter are exported and jsonencoding can see it
 type Movie struct {
-	ID        int       `json:"id"`
-	CreatedAt time.Time `json:"-"` // hide this field
-	Title     string    `json:"title"`
-	Year      int       `json:"year,omitzero"`
-	Runtime   Runtime   `json:"runtime,omitzero,string"`
-	Genres    []string  `json:"genres,omitempty"`
-	Version   int       `json:"version"`
+	ID          int          `json:"id"`
+	CreatedAt   time.Time    `json:"-"` // hide this field
+	Title       string       `json:"title"`
+	Year        int          `json:"year,omitzero"`
+	Runtime     Runtime      `json:"runtime,omitzero,string"`
+	Genres      []string     `json:"genres,omitempty"`
+	Rating      float64      `json:"rating"` // average of the reviews, 0 when there are none
+	Votes       int          `json:"votes"`
+	Images      *MovieImages `json:"images,omitempty"` // nil until a poster is uploaded
+	ExternalIDs ExternalIDs  `json:"external_ids,omitempty"`
+	Version     int          `json:"version"`
+	DeletedAt   time.Time    `json:"deleted_at,omitzero"` // only set for movies in the trash
+	Relevance   float64      `json:"relevance,omitzero"`  // only set when searching by title
+	Score       float64      `json:"score,omitzero"`      // only set for similar movies
+
+	// only set when highlighting is requested, the title is HTML escaped so the highlight tags are its only markup
+	HighlightedTitle string `json:"highlighted_title,omitempty"`
+
+	// only set when Title was swapped for a localized one
+	OriginalTitle string `json:"original_title,omitempty"`
+	TitleLanguage string `json:"title_language,omitempty"`
+
+	Credits []*Credit `json:"credits,omitzero"` // only set with ?include=credits, empty when there are none
+
+	RuntimeFormat RuntimeFormat `json:"-"` // how Runtime is encoded, RuntimeMins when empty
+}
+
+// MarshalJSON encodes the movie as it is, except for Runtime which is written in RuntimeFormat
+func (movie Movie) MarshalJSON() ([]byte, error) {
+	// plain has the same fields but not this method, otherwise encoding it would recurse
+	type plain Movie
+
+	if movie.RuntimeFormat == "" || movie.RuntimeFormat == RuntimeMins {
+		return json.Marshal(plain(movie))
+	}
+
+	// the runtime field of the outer struct shadows the embedded one
+	formatted := struct {
+		plain
+		Runtime any `json:"runtime,omitempty"`
+	}{plain: plain(movie)}
+
+	if movie.Runtime != 0 {
+		formatted.Runtime = movie.Runtime.jsonValue(movie.RuntimeFormat)
+	}
+
+	return json.Marshal(formatted)
 }
 
 type MovieModel struct {
-	DB *sql.DB
+	DB *DB
 }
 
 func ValidateMovie(v *validator.Validator, movie *Movie) {
@@ -46,49 +87,63 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 
 	v.Check(validator.UniqueValues(movie.Genres), "genres", "must not contain duplicate values")
 
+	ValidateExternalIDs(v, movie.ExternalIDs)
+
 }
 
-func (m MovieModel) Insert(movie *Movie) error {
+// Insert creates the movie and its first revision, userID is who created it.
+// It returns ErrDuplicateExternalID when another movie already has one of its external ids
+func (m MovieModel) Insert(ctx context.Context, movie *Movie, userID int) error {
 	query := `
-		INSERT INTO movies (title, year, runtime, genres)
-		VALUES ($1, $2, $3, $4)
-		RETURNING id,created_at, version `
+		WITH inserted AS (
+			INSERT INTO movies (title, year, runtime, genres, external_ids)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING id, created_at, title, year, runtime, genres, version
+		), ` + revisionCTE("inserted", "$6") + `
+		SELECT id, created_at, version FROM inserted`
 	// pq implements the drivers to convert our slice of strings to postgres text[]
-	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.ExternalIDs, nullID(userID)}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Write)
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	if err != nil {
+		switch {
+		case isDuplicateExternalID(err):
+			return ErrDuplicateExternalID
+		default:
+			return err
+		}
+	}
+	return nil
 }
 
-func (m MovieModel) Get(id int) (*Movie, error) {
+func (m MovieModel) Get(ctx context.Context, id int) (*Movie, error) {
+	return m.GetFields(ctx, id, nil)
+}
+
+// GetFields fetches a movie selecting only the columns behind fields (all of them when fields is empty)
+func (m MovieModel) GetFields(ctx context.Context, id int, fields []string) (*Movie, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 
-	query := `
-		SELECT id, created_at, title, year, runtime, genres, version 
-		FROM movies
-		WHERE id = $1`
-
 	var movie Movie
 
+	columns, dest := movieColumns(&movie, fields)
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM movies
+		WHERE id = $1 AND deleted_at IS NULL`, columns)
+
 	// context that holds a 3 second timeout deadline
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Read)
 	// cancel releases the resources associated with the context, otherwise after 3 seconds the resources will not be released
 	defer cancel()
 
-	//err := m.DB.QueryRow(query, id).Scan(
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
-		&movie.ID,
-		&movie.CreatedAt,
-		&movie.Title,
-		&movie.Year,
-		&movie.Runtime,
-		pq.Array(&movie.Genres),
-		&movie.Version,
-	)
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(dest...)
 
 	if err != nil {
 		switch {
@@ -102,23 +157,29 @@ func (m MovieModel) Get(id int) (*Movie, error) {
 	return &movie, nil
 }
 
-func (m MovieModel) Update(movie *Movie) error {
+// Update saves the movie as a new version and keeps that version as a revision, userID is who changed it
+func (m MovieModel) Update(ctx context.Context, movie *Movie, userID int) error {
 	// use uuid_generate_v4() so that the version is't guessable
-	query := `UPDATE movies
-	          SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-	          WHERE id = $5 AND version = $6
-	          RETURNING version `
+	query := `WITH updated AS (
+	              UPDATE movies
+	              SET title = $1, year = $2, runtime = $3, genres = $4, external_ids = $5, version = version + 1
+	              WHERE id = $6 AND version = $7 AND deleted_at IS NULL
+	              RETURNING id, created_at, title, year, runtime, genres, version
+	          ), ` + revisionCTE("updated", "$8") + `
+	          SELECT version FROM updated`
 
 	args := []any{
 		movie.Title,
 		movie.Year,
 		movie.Runtime,
 		pq.Array(movie.Genres),
+		movie.ExternalIDs,
 		movie.ID,
 		movie.Version,
+		nullID(userID),
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Write)
 	defer cancel()
 
 	// check if no mathcing rows have been found, the version has changed
@@ -127,6 +188,8 @@ func (m MovieModel) Update(movie *Movie) error {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
+		case isDuplicateExternalID(err):
+			return ErrDuplicateExternalID
 		default:
 			return err
 		}
@@ -134,18 +197,20 @@ func (m MovieModel) Update(movie *Movie) error {
 	return nil
 }
 
-func (m MovieModel) Delete(id int) error {
+// Delete moves a movie to the trash, it's only removed for good by Purge
+func (m MovieModel) Delete(ctx context.Context, id int) error {
 
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
 	query := `
-	DELETE  FROM movies
-	WHERE id = $1
+	UPDATE movies
+	SET deleted_at = NOW()
+	WHERE id = $1 AND deleted_at IS NULL
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Write)
 	defer cancel()
 
 	result, err := m.DB.ExecContext(ctx, query, id)
@@ -167,19 +232,75 @@ func (m MovieModel) Delete(id int) error {
 	return nil
 }
 
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
-	query := fmt.Sprintf(`
-		SELECT count(*) OVER(), id, created_at,title, year, runtime, genres, version 
+// MovieQuery holds everything a movie listing can be narrowed, shaped and paged by
+type MovieQuery struct {
+	Title     string
+	Genres    []string
+	Search    SearchMode
+	Highlight *Highlight // when not nil the matched words in the title are wrapped in its tags
+	Fields    []string   // when empty every field is selected
+	PersonID  int        // when set only movies this person is credited on
+	Filters   Filters
+}
+
+// build assembles the listing query for q, scanning into movie. Paged queries also select the
+// total count and honour Filters.Page, the others return every matching row
+func (q MovieQuery) build(movie *Movie, paged bool) (query string, args []any, dest []any) {
+	args = []any{q.Title, pq.Array(q.Genres)}
+
+	prefix := ""
+	if q.Search == SearchFuzzy {
+		args = append(args, prefixQuery(q.Title))
+		prefix = fmt.Sprintf("$%d", len(args))
+	}
+
+	match, rank, tsquery := titleSearch(q.Search, "$1", prefix)
+
+	headline := "''"
+	if q.Highlight != nil && q.Title != "" {
+		args = append(args, q.Highlight.options())
+		headline = fmt.Sprintf("ts_headline('english', %s, %s, $%d)", escapedTitle, tsquery, len(args))
+	}
+
+	person := ""
+	if q.PersonID > 0 {
+		args = append(args, q.PersonID)
+		person = fmt.Sprintf("AND   id IN (SELECT movie_id FROM movie_credits WHERE person_id = $%d)", len(args))
+	}
+
+	columns, dest := movieColumns(movie, q.Fields)
+	dest = append(dest, &movie.Relevance, &movie.HighlightedTitle)
+
+	count, page := "", ""
+	if paged {
+		args = append(args, q.Filters.limit(), q.Filters.offset())
+		count = "count(*) OVER(), "
+		page = fmt.Sprintf("LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+	}
+
+	query = fmt.Sprintf(`
+		SELECT %s%s, %s AS relevance, %s AS highlighted_title
 		FROM movies
-		WHERE ( to_tsvector('english', title) @@ plainto_tsquery('english', $1) OR $1 = '')
+		WHERE deleted_at IS NULL
+		AND   ( %s OR $1 = '')
 		AND   ( genres @> $2 OR $2 = '{}')
-		ORDER BY %s %s , id ASC
-		LIMIT $3 OFFSET $4`, filters.sortCollumn(), filters.sortDirection())
+		%s
+		ORDER BY %s
+		%s`, count, columns, rank, headline, match, person, q.Filters.orderBy(), page)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	return query, args, dest
+}
+
+func (m MovieModel) GetAll(ctx context.Context, q MovieQuery) ([]*Movie, Metadata, error) {
+	var movie Movie
+	totalRecords := 0
+
+	query, args, dest := q.build(&movie, true)
+	// every row is scanned into the same movie and then copied, so dest only has to be built once
+	dest = append([]any{&totalRecords}, dest...)
 
-	args := []any{title, pq.Array(genres), filters.limit(), filters.offset()}
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Search)
+	defer cancel()
 
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -189,31 +310,24 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 
 	defer rows.Close()
 
-	totalRecords := 0
 	movies := []*Movie{}
+
 	for rows.Next() {
 
-		var movie Movie
-		err := rows.Scan(
-			&totalRecords,
-			&movie.ID,
-			&movie.CreatedAt,
-			&movie.Title,
-			&movie.Year,
-			&movie.Runtime,
-			pq.Array(&movie.Genres),
-			&movie.Version,
-		)
+		movie = Movie{}
+		err := rows.Scan(dest...)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
-		movies = append(movies, &movie)
+
+		scanned := movie
+		movies = append(movies, &scanned)
 	}
-	if rows.Err() != nil {
+	if err = rows.Err(); err != nil {
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	metadata := calculateMetadata(totalRecords, q.Filters.Page, q.Filters.PageSize)
 	return movies, metadata, nil
 
 }
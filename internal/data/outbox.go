@@ -0,0 +1,356 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrAlreadySent = errors.New("email already sent")
+	// ErrLeaseLost is returned when marking an email whose lease ran out and that was claimed again
+	// meanwhile, it belongs to the newer claim now
+	ErrLeaseLost = errors.New("email lease lost")
+)
+
+const (
+	OutboxPending = "pending"
+	OutboxSent    = "sent"
+	OutboxDead    = "dead" // ran out of attempts, only sent again when retried by hand
+)
+
+var OutboxStatusSafeList = []string{OutboxPending, OutboxSent, OutboxDead}
+
+// OutboxEmail is an email waiting in the outbox, or what's left of it once it went out
+type OutboxEmail struct {
+	ID             int            `json:"id"`
+	CreatedAt      time.Time      `json:"created_at"`
+	IdempotencyKey string         `json:"idempotency_key"` // queuing an email with a key that's already there does nothing
+	Recipient      string         `json:"recipient"`
+	Template       string         `json:"template"`
+	Data           map[string]any `json:"-"` // it can hold secrets like activation tokens, it's dropped once sent
+	Status         string         `json:"status"`
+	Attempts       int            `json:"attempts"`
+	NextAttemptAt  time.Time      `json:"next_attempt_at,omitzero"`
+	LastError      string         `json:"last_error,omitempty"`
+	SentAt         time.Time      `json:"sent_at,omitzero"`
+	LockedUntil    time.Time      `json:"locked_until,omitzero"` // the end of the lease of the latest claim, it tells claims apart
+}
+
+// MessageID is the Message-ID the email is sent with, the same on every attempt so that a mail server
+// that got it already can tell it's a resend
+func (email *OutboxEmail) MessageID() string {
+	return fmt.Sprintf("outbox.%d.%d@greenlight", email.ID, email.CreatedAt.Unix())
+}
+
+// OutboxRetry is how failed deliveries are retried: the wait starts at Backoff and doubles after every
+// attempt up to MaxBackoff, after MaxAttempts the email is dead-lettered
+type OutboxRetry struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+// delay is how long to wait after the given number of failed attempts
+func (retry OutboxRetry) delay(attempts int) time.Duration {
+	delay := retry.Backoff
+	for i := 1; i < attempts && delay < retry.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, retry.MaxBackoff)
+}
+
+type OutboxModel struct {
+	DB *DB
+}
+
+const outboxColumns = `id, created_at, idempotency_key, recipient, template, data, status, attempts, next_attempt_at,
+	coalesce(last_error, ''), sent_at, locked_until`
+
+// scanOutboxEmail scans the outboxColumns of a row
+func scanOutboxEmail(row interface{ Scan(...any) error }, email *OutboxEmail, extra ...any) error {
+	var data []byte
+	var sentAt, lockedUntil sql.NullTime
+
+	dest := []any{
+		&email.ID,
+		&email.CreatedAt,
+		&email.IdempotencyKey,
+		&email.Recipient,
+		&email.Template,
+		&data,
+		&email.Status,
+		&email.Attempts,
+		&email.NextAttemptAt,
+		&email.LastError,
+		&sentAt,
+		&lockedUntil,
+	}
+
+	err := row.Scan(append(extra, dest...)...)
+	if err != nil {
+		return err
+	}
+
+	email.SentAt = sentAt.Time
+	email.LockedUntil = lockedUntil.Time
+	return decodeEmailData(data, email)
+}
+
+// decodeEmailData keeps numbers as they were written, a float64 user id of 1000000 would print as 1e+06
+func decodeEmailData(data []byte, email *OutboxEmail) error {
+	email.Data = nil
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	err := dec.Decode(&email.Data)
+	if err != nil {
+		return err
+	}
+
+	if len(email.Data) == 0 {
+		email.Data = nil
+	}
+	return nil
+}
+
+// Enqueue puts the email in the outbox, to be sent by the worker once the transaction it's part of
+// commits. When an email with the same idempotency key is already there nothing happens and ID stays 0
+func (m OutboxModel) Enqueue(ctx context.Context, email *OutboxEmail) error {
+	query := `
+		INSERT INTO email_outbox (idempotency_key, recipient, template, data)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (idempotency_key) DO NOTHING
+		RETURNING id, created_at, status, next_attempt_at`
+
+	data, err := json.Marshal(email.Data)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Write)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, email.IdempotencyKey, email.Recipient, email.Template, data).Scan(
+		&email.ID,
+		&email.CreatedAt,
+		&email.Status,
+		&email.NextAttemptAt,
+	)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	return nil
+}
+
+// Claim takes up to limit due emails for sending, counting the attempt. Nobody else can claim them for
+// the length of lease, by then they must have been marked sent or failed. An email whose worker died
+// mid send is claimed again once its lease runs out, the old claim then gets ErrLeaseLost
+func (m OutboxModel) Claim(ctx context.Context, limit int, lease time.Duration) ([]*OutboxEmail, error) {
+	// SKIP LOCKED lets several workers claim at once without waiting on each other's rows
+	query := `
+		WITH due AS (
+			SELECT id FROM email_outbox
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			AND   (locked_until IS NULL OR locked_until < NOW())
+			ORDER BY next_attempt_at, id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE email_outbox
+		SET attempts = attempts + 1, locked_until = NOW() + make_interval(secs => $2)
+		FROM due
+		WHERE email_outbox.id = due.id
+		RETURNING ` + outboxColumns
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Write)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	emails := []*OutboxEmail{}
+	for rows.Next() {
+		var email OutboxEmail
+		err := scanOutboxEmail(rows, &email)
+		if err != nil {
+			return nil, err
+		}
+		emails = append(emails, &email)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return emails, nil
+}
+
+// MarkSent records the email went out. Its data isn't needed anymore and is dropped
+func (m OutboxModel) MarkSent(ctx context.Context, email *OutboxEmail) error {
+	query := `
+		UPDATE email_outbox
+		SET status = 'sent', sent_at = NOW(), data = '{}', locked_until = NULL, last_error = NULL
+		WHERE id = $1 AND status = 'pending' AND locked_until = $2
+		RETURNING sent_at`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Write)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, email.ID, email.LockedUntil).Scan(&email.SentAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrLeaseLost
+		default:
+			return err
+		}
+	}
+
+	email.Status, email.Data, email.LastError, email.LockedUntil = OutboxSent, nil, "", time.Time{}
+	return nil
+}
+
+// MarkFailed records a failed attempt, the email is tried again after retry's backoff or is dead-lettered
+// when it was the last attempt. Status and NextAttemptAt tell which
+func (m OutboxModel) MarkFailed(ctx context.Context, email *OutboxEmail, sendErr error, retry OutboxRetry) error {
+	status, next := failedAttempt(email, retry)
+
+	query := `
+		UPDATE email_outbox
+		SET status = $2, next_attempt_at = $3, last_error = $4, locked_until = NULL
+		WHERE id = $1 AND status = 'pending' AND locked_until = $5`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Write)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, email.ID, status, next, sendErr.Error(), email.LockedUntil)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrLeaseLost
+	}
+
+	email.Status, email.NextAttemptAt, email.LastError, email.LockedUntil = status, next, sendErr.Error(), time.Time{}
+	return nil
+}
+
+// failedAttempt returns the status and next attempt of an email whose latest attempt failed
+func failedAttempt(email *OutboxEmail, retry OutboxRetry) (string, time.Time) {
+	if email.Attempts >= retry.MaxAttempts {
+		return OutboxDead, email.NextAttemptAt
+	}
+	return OutboxPending, time.Now().Add(retry.delay(email.Attempts))
+}
+
+func (m OutboxModel) Get(ctx context.Context, id int) (*OutboxEmail, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT ` + outboxColumns + ` FROM email_outbox WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Read)
+	defer cancel()
+
+	var email OutboxEmail
+	err := scanOutboxEmail(m.DB.QueryRowContext(ctx, query, id), &email)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &email, nil
+}
+
+// GetAll lists the emails in the outbox, only the ones with status unless it's empty
+func (m OutboxModel) GetAll(ctx context.Context, status string, filters Filters) ([]*OutboxEmail, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), %s
+		FROM email_outbox
+		WHERE status = $1 OR $1 = ''
+		ORDER BY %s
+		LIMIT $2 OFFSET $3`, outboxColumns, filters.orderBy())
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Search)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	emails := []*OutboxEmail{}
+	for rows.Next() {
+		var email OutboxEmail
+		err := scanOutboxEmail(rows, &email, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		emails = append(emails, &email)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return emails, metadata, nil
+}
+
+// Retry puts an email that wasn't sent back in line to go out right away, with all its attempts ahead of it.
+// It returns ErrAlreadySent for sent emails and ErrEditConflict when a worker is sending it right now
+func (m OutboxModel) Retry(ctx context.Context, id int) (*OutboxEmail, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		UPDATE email_outbox
+		SET status = 'pending', attempts = 0, next_attempt_at = NOW()
+		WHERE id = $1 AND status <> 'sent' AND (locked_until IS NULL OR locked_until < NOW())
+		RETURNING ` + outboxColumns
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Write)
+	defer cancel()
+
+	var email OutboxEmail
+	err := scanOutboxEmail(m.DB.QueryRowContext(ctx, query, id), &email)
+	if err == nil {
+		return &email, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	// nothing was updated, the status says why
+	var status string
+	err = m.DB.QueryRowContext(ctx, `SELECT status FROM email_outbox WHERE id = $1`, id).Scan(&status)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, ErrRecordNotFound
+	case err != nil:
+		return nil, err
+	case status == OutboxSent:
+		return nil, ErrAlreadySent
+	default:
+		return nil, ErrEditConflict
+	}
+}
@@ -0,0 +1,360 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"greenlight/internal/validator"
+)
+
+var (
+	ErrDuplicateCredit = errors.New("duplicate credit")
+
+	// CreditRoles are what a person can be credited as on a movie
+	CreditRoles = []string{"director", "actor", "writer"}
+)
+
+type Person struct {
+	ID        int       `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Name      string    `json:"name"`
+	BirthYear int       `json:"birth_year,omitzero"`
+	Biography string    `json:"biography,omitempty"`
+	Version   int       `json:"version"`
+}
+
+// Credit links a person to a movie. Billing orders the credits of the same role, lower comes first
+type Credit struct {
+	ID        int    `json:"id"`
+	MovieID   int    `json:"movie_id"`
+	PersonID  int    `json:"person_id"`
+	Name      string `json:"name,omitempty"`  // the person's name, filled in when listing a movie's credits
+	Title     string `json:"title,omitempty"` // the movie's title, filled in for filmographies
+	Year      int    `json:"year,omitzero"`   // the movie's year, filled in for filmographies
+	Role      string `json:"role"`
+	Character string `json:"character,omitempty"`
+	Billing   int    `json:"billing_order"`
+}
+
+type PeopleModel struct {
+	DB *DB
+}
+
+func ValidatePerson(v *validator.Validator, person *Person) {
+	v.Check(person.Name != "", "name", "must be provided")
+	v.Check(len(person.Name) <= 500, "name", "must not be more than 500 bytes long")
+
+	// birth year is optional
+	if person.BirthYear != 0 {
+		v.Check(person.BirthYear >= 1800, "birth_year", "must be greater than 1800")
+		v.Check(person.BirthYear <= time.Now().Year(), "birth_year", "must not be in the future")
+	}
+
+	v.Check(len(person.Biography) <= 10_000, "biography", "must not be more than 10000 bytes long")
+}
+
+func ValidateCredit(v *validator.Validator, credit *Credit) {
+	v.Check(credit.PersonID > 0, "person_id", "must be provided")
+	v.Check(validator.PermittedValue(credit.Role, CreditRoles...), "role", "must be director, actor or writer")
+	v.Check(credit.Billing >= 0, "billing_order", "must not be negative")
+	v.Check(len(credit.Character) <= 500, "character", "must not be more than 500 bytes long")
+	v.Check(credit.Character == "" || credit.Role == "actor", "character", "only actors play a character")
+}
+
+func (m PeopleModel) Insert(ctx context.Context, person *Person) error {
+	query := `
+		INSERT INTO people (name, birth_year, biography)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at, version`
+
+	args := []any{person.Name, person.BirthYear, person.Biography}
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Write)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&person.ID, &person.CreatedAt, &person.Version)
+}
+
+func (m PeopleModel) Get(ctx context.Context, id int) (*Person, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, name, birth_year, biography, version
+		FROM people
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Read)
+	defer cancel()
+
+	var person Person
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&person.ID,
+		&person.CreatedAt,
+		&person.Name,
+		&person.BirthYear,
+		&person.Biography,
+		&person.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &person, nil
+}
+
+func (m PeopleModel) Update(ctx context.Context, person *Person) error {
+	query := `
+		UPDATE people
+		SET name = $1, birth_year = $2, biography = $3, version = version + 1
+		WHERE id = $4 AND version = $5
+		RETURNING version`
+
+	args := []any{person.Name, person.BirthYear, person.Biography, person.ID, person.Version}
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Write)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&person.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes a person together with all their credits
+func (m PeopleModel) Delete(ctx context.Context, id int) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM people
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Write)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetAll lists people, name matches anywhere in the name ignoring case
+func (m PeopleModel) GetAll(ctx context.Context, name string, filters Filters) ([]*Person, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, name, birth_year, biography, version
+		FROM people
+		WHERE (name ILIKE $1 OR $2 = '')
+		ORDER BY %s
+		LIMIT $3 OFFSET $4`, filters.orderBy())
+
+	args := []any{"%" + escapeLike(name) + "%", name, filters.limit(), filters.offset()}
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Search)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	people := []*Person{}
+	for rows.Next() {
+		var person Person
+		err := rows.Scan(
+			&totalRecords,
+			&person.ID,
+			&person.CreatedAt,
+			&person.Name,
+			&person.BirthYear,
+			&person.Biography,
+			&person.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		people = append(people, &person)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return people, metadata, nil
+}
+
+// AddCredit links a person to a movie, it returns ErrRecordNotFound when the person doesn't exist
+func (m PeopleModel) AddCredit(ctx context.Context, credit *Credit) error {
+	query := `
+		INSERT INTO movie_credits (movie_id, person_id, role, character, billing_order)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id`
+
+	args := []any{credit.MovieID, credit.PersonID, credit.Role, credit.Character, credit.Billing}
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Write)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&credit.ID)
+	if err != nil {
+		switch {
+		case strings.HasPrefix(err.Error(), `pq: duplicate key value violates unique constraint "movie_credits_movie_id_person_id_role_key"`):
+			return ErrDuplicateCredit
+		case strings.HasPrefix(err.Error(), `pq: insert or update on table "movie_credits" violates foreign key constraint`):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m PeopleModel) DeleteCredit(ctx context.Context, movieID int, creditID int) error {
+	query := `
+		DELETE FROM movie_credits
+		WHERE id = $1 AND movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Write)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, creditID, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetCreditsForMovie returns the cast and crew of a movie grouped by role, in billing order
+func (m PeopleModel) GetCreditsForMovie(ctx context.Context, movieID int) ([]*Credit, error) {
+	query := `
+		SELECT movie_credits.id, movie_credits.movie_id, movie_credits.person_id, people.name,
+		       movie_credits.role, movie_credits.character, movie_credits.billing_order
+		FROM movie_credits
+		INNER JOIN people ON people.id = movie_credits.person_id
+		WHERE movie_credits.movie_id = $1
+		ORDER BY movie_credits.role, movie_credits.billing_order, people.name, movie_credits.id`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Read)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits := []*Credit{}
+	for rows.Next() {
+		var credit Credit
+		err := rows.Scan(
+			&credit.ID,
+			&credit.MovieID,
+			&credit.PersonID,
+			&credit.Name,
+			&credit.Role,
+			&credit.Character,
+			&credit.Billing,
+		)
+		if err != nil {
+			return nil, err
+		}
+		credits = append(credits, &credit)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
+
+// GetFilmography returns every credit of a person on a movie that isn't deleted, newest movies first
+func (m PeopleModel) GetFilmography(ctx context.Context, personID int, filters Filters) ([]*Credit, Metadata, error) {
+	// the join is wrapped so the sort keys (and the id tiebreak) refer to unambiguous columns
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, movie_id, person_id, title, year, role, character, billing_order
+		FROM (
+			SELECT movie_credits.id, movie_credits.movie_id, movie_credits.person_id, movies.title, movies.year,
+			       movie_credits.role, movie_credits.character, movie_credits.billing_order
+			FROM movie_credits
+			INNER JOIN movies ON movies.id = movie_credits.movie_id
+			WHERE movie_credits.person_id = $1 AND movies.deleted_at IS NULL
+		) AS filmography
+		ORDER BY %s
+		LIMIT $2 OFFSET $3`, filters.orderBy())
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Search)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, personID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	credits := []*Credit{}
+	for rows.Next() {
+		var credit Credit
+		err := rows.Scan(
+			&totalRecords,
+			&credit.ID,
+			&credit.MovieID,
+			&credit.PersonID,
+			&credit.Title,
+			&credit.Year,
+			&credit.Role,
+			&credit.Character,
+			&credit.Billing,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		credits = append(credits, &credit)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return credits, metadata, nil
+}
@@ -2,9 +2,7 @@ package data
 
 import (
 	"context"
-	"database/sql"
 	"slices"
-	"time"
 
 	"github.com/lib/pq"
 )
@@ -12,14 +10,14 @@ import (
 type Permissions []string
 
 type PermissionsModel struct {
-	DB *sql.DB
+	DB *DB
 }
 
 func (p Permissions) Includes(code string) bool {
 	return slices.Contains(p, code)
 }
 
-func (m *PermissionsModel) AddForUser(userID int, codes ...string) error {
+func (m *PermissionsModel) AddForUser(ctx context.Context, userID int, codes ...string) error {
 
 	query := `
 	INSERT INTO users_permissions
@@ -28,7 +26,7 @@ func (m *PermissionsModel) AddForUser(userID int, codes ...string) error {
 		WHERE permissions.code = ANY($2)
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Write)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
@@ -36,7 +34,7 @@ func (m *PermissionsModel) AddForUser(userID int, codes ...string) error {
 }
 
 // GetAllForUser returns all permission codes for a specific user
-func (m *PermissionsModel) GetAllForUser(userID int) (Permissions, error) {
+func (m *PermissionsModel) GetAllForUser(ctx context.Context, userID int) (Permissions, error) {
 
 	query := `
 	SELECT permissions.code 
@@ -46,13 +44,14 @@ func (m *PermissionsModel) GetAllForUser(userID int) (Permissions, error) {
 	WHERE user_id = $1
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Read)
 	defer cancel()
 
 	rows, err := m.DB.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	var permissions Permissions
 
@@ -64,7 +63,8 @@ func (m *PermissionsModel) GetAllForUser(userID int) (Permissions, error) {
 		}
 		permissions = append(permissions, perm)
 	}
-	if rows.Err() != nil {
+	// a canceled query ends the loop early, it must not pass for a user without permissions
+	if err = rows.Err(); err != nil {
 		return nil, err
 	}
 
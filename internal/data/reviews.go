@@ -0,0 +1,194 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"greenlight/internal/validator"
+)
+
+var ErrDuplicateReview = errors.New("duplicate review")
+
+// Review is a user's rating of a movie, with an optional written review. A user has at most one per movie,
+// the movie's rating and votes are kept up to date by the database whenever reviews change
+type Review struct {
+	ID        int       `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	MovieID   int       `json:"movie_id"`
+	UserID    int       `json:"user_id"`
+	Rating    int       `json:"rating"`
+	Body      string    `json:"body,omitempty"`
+	Version   int       `json:"version"`
+}
+
+type ReviewModel struct {
+	DB *DB
+}
+
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(review.Rating >= 1, "rating", "must be at least 1")
+	v.Check(review.Rating <= 10, "rating", "must not be more than 10")
+	v.Check(len(review.Body) <= 10_000, "body", "must not be more than 10000 bytes long")
+}
+
+// Insert saves a new review, it returns ErrDuplicateReview when the user already reviewed the movie
+func (m ReviewModel) Insert(ctx context.Context, review *Review) error {
+	query := `
+		INSERT INTO reviews (movie_id, user_id, rating, body)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version`
+
+	args := []any{review.MovieID, review.UserID, review.Rating, review.Body}
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Write)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&review.ID, &review.CreatedAt, &review.Version)
+	if err != nil {
+		switch {
+		case strings.HasPrefix(err.Error(), `pq: duplicate key value violates unique constraint "reviews_movie_id_user_id_key"`):
+			return ErrDuplicateReview
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Get returns a review of the given movie
+func (m ReviewModel) Get(ctx context.Context, movieID int, id int) (*Review, error) {
+	if movieID < 1 || id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, movie_id, user_id, rating, body, version
+		FROM reviews
+		WHERE id = $1 AND movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Read)
+	defer cancel()
+
+	var review Review
+	err := m.DB.QueryRowContext(ctx, query, id, movieID).Scan(
+		&review.ID,
+		&review.CreatedAt,
+		&review.MovieID,
+		&review.UserID,
+		&review.Rating,
+		&review.Body,
+		&review.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &review, nil
+}
+
+func (m ReviewModel) Update(ctx context.Context, review *Review) error {
+	query := `
+		UPDATE reviews
+		SET rating = $1, body = $2, version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING version`
+
+	args := []any{review.Rating, review.Body, review.ID, review.Version}
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Write)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&review.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m ReviewModel) Delete(ctx context.Context, id int) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM reviews
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Write)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetAllForMovie lists the reviews of a movie
+func (m ReviewModel) GetAllForMovie(ctx context.Context, movieID int, filters Filters) ([]*Review, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, movie_id, user_id, rating, body, version
+		FROM reviews
+		WHERE movie_id = $1
+		ORDER BY %s
+		LIMIT $2 OFFSET $3`, filters.orderBy())
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Search)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := []*Review{}
+	for rows.Next() {
+		var review Review
+		err := rows.Scan(
+			&totalRecords,
+			&review.ID,
+			&review.CreatedAt,
+			&review.MovieID,
+			&review.UserID,
+			&review.Rating,
+			&review.Body,
+			&review.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		reviews = append(reviews, &review)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return reviews, metadata, nil
+}
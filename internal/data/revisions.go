@@ -0,0 +1,166 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// MovieRevision is a movie as it was at a given version, and who saved that version
+type MovieRevision struct {
+	MovieID   int       `json:"movie_id"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    int       `json:"user_id,omitzero"` // zero when the user was deleted or for versions older than the history
+	// set on revisions of a duplicate that was merged into this movie, their versions are the duplicate's
+	MergedFrom int      `json:"merged_from,omitzero"`
+	Title      string   `json:"title"`
+	Year       int      `json:"year"`
+	Runtime    Runtime  `json:"runtime,string"`
+	Genres     []string `json:"genres"`
+}
+
+// FieldChange is one field that differs between two revisions
+type FieldChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// revisionCTE returns a WITH clause that stores every row returned by the source CTE as a revision.
+// Writing the movie and its revision in the same statement means one can never exist without the other
+func revisionCTE(source string, userID string) string {
+	return fmt.Sprintf(`revision AS (
+			INSERT INTO movie_revisions (movie_id, version, user_id, title, year, runtime, genres)
+			SELECT id, version, %s::bigint, title, year, runtime, genres FROM %s
+		)`, userID, source)
+}
+
+// nullID stores a zero id as NULL, so foreign keys to users don't break for anonymous writes
+func nullID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id > 0}
+}
+
+// GetRevisions lists the revisions of a movie, newest first unless filters say otherwise
+func (m MovieModel) GetRevisions(ctx context.Context, movieID int, filters Filters) ([]*MovieRevision, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), movie_id, version, created_at, user_id, merged_from, title, year, runtime, genres
+		FROM movie_revisions
+		WHERE movie_id = $1
+		ORDER BY %s
+		LIMIT $2 OFFSET $3`, filters.orderBy())
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Search)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	revisions := []*MovieRevision{}
+	for rows.Next() {
+		var revision MovieRevision
+		var userID, mergedFrom sql.NullInt64
+
+		err := rows.Scan(
+			&totalRecords,
+			&revision.MovieID,
+			&revision.Version,
+			&revision.CreatedAt,
+			&userID,
+			&mergedFrom,
+			&revision.Title,
+			&revision.Year,
+			&revision.Runtime,
+			pq.Array(&revision.Genres),
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		revision.UserID = int(userID.Int64)
+		revision.MergedFrom = int(mergedFrom.Int64)
+		revisions = append(revisions, &revision)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return revisions, metadata, nil
+}
+
+// GetRevision returns a single version of a movie, revisions merged in from duplicates are left out
+func (m MovieModel) GetRevision(ctx context.Context, movieID int, version int) (*MovieRevision, error) {
+	if movieID < 1 || version < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT movie_id, version, created_at, user_id, title, year, runtime, genres
+		FROM movie_revisions
+		WHERE movie_id = $1 AND version = $2 AND merged_from IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Read)
+	defer cancel()
+
+	var revision MovieRevision
+	var userID sql.NullInt64
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, version).Scan(
+		&revision.MovieID,
+		&revision.Version,
+		&revision.CreatedAt,
+		&userID,
+		&revision.Title,
+		&revision.Year,
+		&revision.Runtime,
+		pq.Array(&revision.Genres),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	revision.UserID = int(userID.Int64)
+	return &revision, nil
+}
+
+// DiffRevisions returns the fields that changed going from one revision to the other, keyed by their json name
+func DiffRevisions(from, to *MovieRevision) map[string]FieldChange {
+	changes := make(map[string]FieldChange)
+
+	if from.Title != to.Title {
+		changes["title"] = FieldChange{From: from.Title, To: to.Title}
+	}
+	if from.Year != to.Year {
+		changes["year"] = FieldChange{From: from.Year, To: to.Year}
+	}
+	if from.Runtime != to.Runtime {
+		changes["runtime"] = FieldChange{From: from.Runtime, To: to.Runtime}
+	}
+	if !slices.Equal(from.Genres, to.Genres) {
+		changes["genres"] = FieldChange{From: from.Genres, To: to.Genres}
+	}
+
+	return changes
+}
+
+// Apply copies the content of a revision onto the movie, leaving its id and version alone
+func (revision *MovieRevision) Apply(movie *Movie) {
+	movie.Title = revision.Title
+	movie.Year = revision.Year
+	movie.Runtime = revision.Runtime
+	movie.Genres = slices.Clone(revision.Genres)
+}
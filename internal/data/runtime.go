@@ -3,12 +3,25 @@ package data
 import (
 	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 )
 
 type Runtime int
 
+// RuntimeFormat is how a runtime is written out, a client picks one with ?runtime_format=
+type RuntimeFormat string
+
+const (
+	RuntimeMins         RuntimeFormat = "mins"    // "105 mins", the default
+	RuntimeMinutes      RuntimeFormat = "minutes" // 105, a json number
+	RuntimeHoursMinutes RuntimeFormat = "hm"      // "1h 45m"
+	RuntimeISO8601      RuntimeFormat = "iso8601" // "PT1H45M"
+)
+
+var RuntimeFormatSafeList = []RuntimeFormat{RuntimeMins, RuntimeMinutes, RuntimeHoursMinutes, RuntimeISO8601}
+
 func (r Runtime) MarshalJSON() ([]byte, error) {
 
 	jsonValue := fmt.Sprintf("%d mins", r)
@@ -19,28 +32,208 @@ func (r Runtime) MarshalJSON() ([]byte, error) {
 	return []byte(quotedJSONValue), nil
 }
 
+// Format writes the runtime as text in the given format, an empty format is RuntimeMins.
+// Whatever it returns is read back by ParseRuntime
+func (r Runtime) Format(format RuntimeFormat) string {
+	switch format {
+	case RuntimeMinutes:
+		return strconv.Itoa(int(r))
+	case RuntimeHoursMinutes:
+		hours, minutes := r/60, r%60
+		switch {
+		case hours == 0:
+			return fmt.Sprintf("%dm", minutes)
+		case minutes == 0:
+			return fmt.Sprintf("%dh", hours)
+		default:
+			return fmt.Sprintf("%dh %dm", hours, minutes)
+		}
+	case RuntimeISO8601:
+		hours, minutes := r/60, r%60
+		switch {
+		case hours == 0:
+			return fmt.Sprintf("PT%dM", minutes)
+		case minutes == 0:
+			return fmt.Sprintf("PT%dH", hours)
+		default:
+			return fmt.Sprintf("PT%dH%dM", hours, minutes)
+		}
+	default:
+		return fmt.Sprintf("%d mins", r)
+	}
+}
+
+// jsonValue is what the runtime is encoded as in the given format, RuntimeMinutes is the only number
+func (r Runtime) jsonValue(format RuntimeFormat) any {
+	if format == RuntimeMinutes {
+		return int(r)
+	}
+	return r.Format(format)
+}
+
 var ErrInvalidRuntimeFormat = errors.New("invalid runtime error")
 
+// UnmarshalJSON takes a string in any format ParseRuntime reads, or a plain number of minutes
 func (r *Runtime) UnmarshalJSON(jsonValue []byte) error {
 
-	unquotedJSONValue, err := strconv.Unquote(string(jsonValue))
+	s := string(jsonValue)
+	if strings.HasPrefix(s, `"`) {
+		unquoted, err := strconv.Unquote(s)
+		if err != nil {
+			return ErrInvalidRuntimeFormat
+		}
+		s = unquoted
+	} else if !isDigits(s) {
+		// a number, but only a whole and positive one is a runtime
+		return ErrInvalidRuntimeFormat
+	}
 
+	runtime, err := ParseRuntime(s)
 	if err != nil {
-		return ErrInvalidRuntimeFormat
+		return err
 	}
 
-	parts := strings.Split(unquotedJSONValue, " ")
+	*r = runtime
+	return nil
+}
 
-	if len(parts) != 2 || parts[1] != "mins" {
-		return ErrInvalidRuntimeFormat
-	}
+// runtimeUnits maps the units ParseRuntime understands to how many minutes they are
+var runtimeUnits = map[string]int64{
+	"h": 60, "hr": 60, "hrs": 60, "hour": 60, "hours": 60,
+	"m": 1, "min": 1, "mins": 1, "minute": 1, "minutes": 1,
+}
+
+// ParseRuntime reads a runtime given in minutes ("105", "105 mins", "105 minutes", "105m"), in hours and
+// minutes ("1h 45m", "1h45m", "1 hour 45 minutes") or as an ISO 8601 duration ("PT1H45M", "PT6300S").
+// Case and spacing don't matter. It's shared with the inputs that aren't JSON (e.g. CSV imports)
+func ParseRuntime(s string) (Runtime, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
 
-	i, err := strconv.Atoi(parts[0])
+	var minutes int64
+	var err error
 
+	if rest, ok := strings.CutPrefix(s, "pt"); ok {
+		minutes, err = parseISO8601Runtime(rest)
+	} else {
+		minutes, err = parseUnitsRuntime(s)
+	}
 	if err != nil {
-		return ErrInvalidRuntimeFormat
+		return 0, err
 	}
 
-	*r = Runtime(i)
-	return nil
+	return Runtime(minutes), nil
+}
+
+// parseUnitsRuntime reads numbers followed by a unit, hours before minutes. A lone number is minutes
+func parseUnitsRuntime(s string) (int64, error) {
+	if s == "" {
+		return 0, ErrInvalidRuntimeFormat
+	}
+
+	if isDigits(s) {
+		n, err := parseRuntimeNumber(s, 1)
+		if err != nil || n > math.MaxInt32 {
+			return 0, ErrInvalidRuntimeFormat
+		}
+		return n, nil
+	}
+
+	var total int64
+	// the unit of the previous number, so hours can't come after minutes or twice
+	last := int64(math.MaxInt64)
+
+	for s != "" {
+		digits := leadingDigits(s)
+		if digits == "" {
+			return 0, ErrInvalidRuntimeFormat
+		}
+		s = strings.TrimLeft(s[len(digits):], " ")
+
+		unit := strings.TrimLeft(s, "abcdefghijklmnopqrstuvwxyz")
+		unit = s[:len(s)-len(unit)]
+		s = strings.TrimLeft(s[len(unit):], " ")
+
+		factor, ok := runtimeUnits[unit]
+		if !ok || factor >= last {
+			return 0, ErrInvalidRuntimeFormat
+		}
+		last = factor
+
+		n, err := parseRuntimeNumber(digits, factor)
+		if err != nil {
+			return 0, err
+		}
+
+		total += n
+		if total > math.MaxInt32 {
+			return 0, ErrInvalidRuntimeFormat
+		}
+	}
+
+	return total, nil
+}
+
+// parseISO8601Runtime reads what follows "PT" in an ISO 8601 duration. Seconds are allowed as long
+// as the whole duration is a number of minutes
+func parseISO8601Runtime(s string) (int64, error) {
+	if s == "" {
+		return 0, ErrInvalidRuntimeFormat
+	}
+
+	var seconds int64
+	// the designator that can come next, each one at most once and in order
+	next := 0
+
+	for s != "" {
+		digits := leadingDigits(s)
+		if digits == "" || len(s) == len(digits) {
+			return 0, ErrInvalidRuntimeFormat
+		}
+
+		i := strings.IndexByte("hms", s[len(digits)])
+		if i < next {
+			return 0, ErrInvalidRuntimeFormat
+		}
+		next = i + 1
+
+		n, err := parseRuntimeNumber(digits, []int64{3600, 60, 1}[i])
+		if err != nil {
+			return 0, err
+		}
+
+		seconds += n
+		if seconds > math.MaxInt32*60 {
+			return 0, ErrInvalidRuntimeFormat
+		}
+
+		s = s[len(digits)+1:]
+	}
+
+	if seconds%60 != 0 {
+		return 0, ErrInvalidRuntimeFormat
+	}
+
+	return seconds / 60, nil
+}
+
+// parseRuntimeNumber returns digits times factor, refusing anything that doesn't fit a runtime
+func parseRuntimeNumber(digits string, factor int64) (int64, error) {
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || n > math.MaxInt32*60/factor {
+		return 0, ErrInvalidRuntimeFormat
+	}
+
+	return n * factor, nil
+}
+
+func leadingDigits(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
+
+func isDigits(s string) bool {
+	return s != "" && leadingDigits(s) == s
 }
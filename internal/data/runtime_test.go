@@ -0,0 +1,257 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseRuntime(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Runtime
+	}{
+		{"105 mins", 105},
+		{"105", 105},
+		{"0", 0},
+		{"  105 mins  ", 105},
+		{"105 min", 105},
+		{"105 minutes", 105},
+		{"1 minute", 1},
+		{"105m", 105},
+		{"105MINS", 105},
+		{"1h 45m", 105},
+		{"1h45m", 105},
+		{"1 h 45 m", 105},
+		{"1 hour 45 minutes", 105},
+		{"2 hours", 120},
+		{"2h", 120},
+		{"1hr 5min", 65},
+		{"3 hrs", 180},
+		{"0h 90m", 90},
+		{"PT1H45M", 105},
+		{"pt1h45m", 105},
+		{"PT105M", 105},
+		{"PT2H", 120},
+		{"PT6300S", 105},
+		{"PT1H44M60S", 105},
+		{"PT0M", 0},
+		{"2147483647", 2147483647},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseRuntime(tt.input)
+			if err != nil {
+				t.Fatalf("ParseRuntime(%q) returned error %v", tt.input, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseRuntime(%q) = %d, want %d", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRuntimeInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"   ",
+		"mins",
+		"105 mins extra",
+		"105 secs",
+		"-5 mins",
+		"+5",
+		"1.5h",
+		"1,5 hours",
+		"45m 1h",
+		"1h 2h",
+		"30m 15m",
+		"1h 45",
+		"h45m",
+		"105 mins mins",
+		"１０５",
+		"PT",
+		"PT1",
+		"PT1H45",
+		"PT45M1H",
+		"PT1H1H",
+		"PT1X",
+		"PT-1H",
+		"PT90S",
+		"PT1H45M30S",
+		"P1D",
+		"2147483648",
+		"99999999999999999999",
+		"35791395h",
+		"PT35791395H",
+		"PT999999999999999999999S",
+	}
+
+	for _, input := range tests {
+		t.Run(input, func(t *testing.T) {
+			got, err := ParseRuntime(input)
+			if !errors.Is(err, ErrInvalidRuntimeFormat) {
+				t.Errorf("ParseRuntime(%q) = %d, %v, want ErrInvalidRuntimeFormat", input, got, err)
+			}
+		})
+	}
+}
+
+func TestRuntimeFormat(t *testing.T) {
+	tests := []struct {
+		runtime Runtime
+		format  RuntimeFormat
+		want    string
+	}{
+		{105, "", "105 mins"},
+		{105, RuntimeMins, "105 mins"},
+		{105, RuntimeMinutes, "105"},
+		{105, RuntimeHoursMinutes, "1h 45m"},
+		{120, RuntimeHoursMinutes, "2h"},
+		{45, RuntimeHoursMinutes, "45m"},
+		{0, RuntimeHoursMinutes, "0m"},
+		{105, RuntimeISO8601, "PT1H45M"},
+		{120, RuntimeISO8601, "PT2H"},
+		{45, RuntimeISO8601, "PT45M"},
+		{0, RuntimeISO8601, "PT0M"},
+	}
+
+	for _, tt := range tests {
+		got := tt.runtime.Format(tt.format)
+		if got != tt.want {
+			t.Errorf("Runtime(%d).Format(%q) = %q, want %q", tt.runtime, tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestRuntimeUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Runtime
+		wantErr bool
+	}{
+		{`"105 mins"`, 105, false},
+		{`"1h 45m"`, 105, false},
+		{`"PT1H45M"`, 105, false},
+		{`"105"`, 105, false},
+		{`105`, 105, false},
+		{`"1h 45m`, 0, true},
+		{`105.5`, 0, true},
+		{`-105`, 0, true},
+		{`1e2`, 0, true},
+		{`true`, 0, true},
+		{`null`, 0, true},
+		{`"nonsense"`, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			var got Runtime
+			err := got.UnmarshalJSON([]byte(tt.input))
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidRuntimeFormat) {
+					t.Errorf("UnmarshalJSON(%s) = %d, %v, want ErrInvalidRuntimeFormat", tt.input, got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("UnmarshalJSON(%s) returned error %v", tt.input, err)
+			}
+			if got != tt.want {
+				t.Errorf("UnmarshalJSON(%s) = %d, want %d", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMovieMarshalJSONRuntimeFormat(t *testing.T) {
+	tests := []struct {
+		format RuntimeFormat
+		want   string
+	}{
+		{"", `"runtime":"105 mins"`},
+		{RuntimeMins, `"runtime":"105 mins"`},
+		{RuntimeMinutes, `"runtime":105`},
+		{RuntimeHoursMinutes, `"runtime":"1h 45m"`},
+		{RuntimeISO8601, `"runtime":"PT1H45M"`},
+	}
+
+	for _, tt := range tests {
+		movie := Movie{ID: 1, Title: "Casablanca", Runtime: 105, RuntimeFormat: tt.format}
+
+		js, err := json.Marshal(&movie)
+		if err != nil {
+			t.Fatalf("marshalling with format %q: %v", tt.format, err)
+		}
+		if !strings.Contains(string(js), tt.want) || strings.Count(string(js), `"runtime"`) != 1 {
+			t.Errorf("format %q: got %s, want it to contain %s once", tt.format, js, tt.want)
+		}
+		if strings.Contains(string(js), "RuntimeFormat") {
+			t.Errorf("format %q: the format leaked into %s", tt.format, js)
+		}
+	}
+
+	// a missing runtime stays missing whatever the format
+	js, err := json.Marshal(Movie{Title: "Casablanca", RuntimeFormat: RuntimeMinutes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(js), `"runtime"`) {
+		t.Errorf("got %s, want no runtime", js)
+	}
+}
+
+func FuzzParseRuntime(f *testing.F) {
+	for _, seed := range []string{"105 mins", "105", "1h 45m", "1 hour 45 minutes", "PT1H45M", "PT6300S", "", "h", "PT", "1h1h"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		runtime, err := ParseRuntime(input)
+		if err != nil {
+			if !errors.Is(err, ErrInvalidRuntimeFormat) {
+				t.Fatalf("ParseRuntime(%q) returned unexpected error %v", input, err)
+			}
+			return
+		}
+
+		if runtime < 0 || runtime > 1<<31-1 {
+			t.Fatalf("ParseRuntime(%q) = %d, out of range", input, runtime)
+		}
+
+		// whatever was accepted comes back the same through every output format
+		for _, format := range RuntimeFormatSafeList {
+			text := runtime.Format(format)
+			again, err := ParseRuntime(text)
+			if err != nil || again != runtime {
+				t.Fatalf("ParseRuntime(%q) = %d, %v after formatting %d as %s", text, again, err, runtime, format)
+			}
+		}
+	})
+}
+
+func FuzzRuntimeUnmarshalJSON(f *testing.F) {
+	for _, seed := range []string{`"105 mins"`, `105`, `"PT1H45M"`, `"1h 45m"`, `null`, `-1`, `"1h"`} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		var runtime Runtime
+		err := runtime.UnmarshalJSON(input)
+		if err != nil {
+			return
+		}
+
+		// an accepted runtime encodes to json that decodes back to it
+		js, err := json.Marshal(runtime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var again Runtime
+		err = json.Unmarshal(js, &again)
+		if err != nil || again != runtime {
+			t.Fatalf("%s decoded to %d, encoded as %s, decoded again to %d, %v", input, runtime, js, again, err)
+		}
+	})
+}
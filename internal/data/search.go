@@ -0,0 +1,148 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"greenlight/internal/validator"
+)
+
+// SearchMode selects how the title parameter of a movie listing is matched
+type SearchMode string
+
+const (
+	// SearchFullText matches whole words using the english text search config
+	SearchFullText SearchMode = "fulltext"
+	// SearchFuzzy tolerates typos (pg_trgm) and partial words (prefix matching)
+	SearchFuzzy SearchMode = "fuzzy"
+)
+
+var SearchModeSafeList = []SearchMode{SearchFullText, SearchFuzzy}
+
+// titleSearch returns the WHERE condition, the relevance expression and the tsquery used by a mode.
+// title is the placeholder holding the raw title, prefix the one holding prefixQuery(title) (fuzzy mode only).
+// Localized titles are matched too, each one with the text search config of its language
+func titleSearch(mode SearchMode, title, prefix string) (match, rank, tsquery string) {
+	var localized, localizedRank string
+
+	switch mode {
+	case SearchFuzzy:
+		tsquery = `to_tsquery('english', ` + prefix + `)`
+		match = `(` + title + ` <% title OR to_tsvector('english', title) @@ ` + tsquery
+		rank = `GREATEST(word_similarity(` + title + `, title), ts_rank(to_tsvector('english', title), ` + tsquery + `)`
+		localized = title + ` <% title`
+		localizedRank = `word_similarity(` + title + `, title)`
+	default:
+		tsquery = `plainto_tsquery('english', ` + title + `)`
+		match = `(to_tsvector('english', title) @@ ` + tsquery
+		rank = `GREATEST(ts_rank(to_tsvector('english', title), ` + tsquery + `)`
+		localized = localizedTitleMatch(title)
+		localizedRank = `ts_rank(search_vector, plainto_tsquery(search_config, ` + title + `))`
+	}
+
+	match += ` OR id IN (SELECT movie_id FROM movie_titles WHERE ` + localized + `))`
+	// GREATEST skips the NULL of a movie without localized titles
+	rank += `, (SELECT max(` + localizedRank + `) FROM movie_titles WHERE movie_id = movies.id))`
+
+	return match, rank, tsquery
+}
+
+// Highlight holds the tags ts_headline wraps around the words that matched a title search
+type Highlight struct {
+	StartSel string
+	StopSel  string
+}
+
+// ValidateHighlight checks the tags can be safely embedded in the ts_headline options string
+func ValidateHighlight(v *validator.Validator, h Highlight) {
+	for _, sel := range []string{h.StartSel, h.StopSel} {
+		v.Check(sel != "", "highlight", "start and stop tags must be provided")
+		v.Check(!strings.ContainsAny(sel, `",`), "highlight", "tags must not contain quotes or commas")
+	}
+}
+
+// escapedTitle is the title with its HTML escaped like html.EscapeString does, highlighting runs on it so the
+// only markup in a highlighted title is the highlight tags. ts_headline leaves the entities alone
+const escapedTitle = `replace(replace(replace(replace(replace(title, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&#34;'), '''', '&#39;')`
+
+// options builds the ts_headline options, HighlightAll makes it return the whole title instead of a fragment
+func (h Highlight) options() string {
+	return fmt.Sprintf(`StartSel="%s", StopSel="%s", HighlightAll=true`, h.StartSel, h.StopSel)
+}
+
+// prefixQuery turns "star wa" into "star:* & wa:*" so that every word also matches as a prefix.
+// Anything that isn't a letter or a digit is dropped, so the result is always valid to_tsquery input
+func prefixQuery(title string) string {
+	words := strings.FieldsFunc(title, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for i, word := range words {
+		words[i] = word + ":*"
+	}
+
+	return strings.Join(words, " & ")
+}
+
+// MovieSuggestion is the trimmed down movie returned by title autocomplete
+type MovieSuggestion struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+	Year  int    `json:"year,omitzero"`
+}
+
+func ValidateSuggestion(v *validator.Validator, q string, limit int) {
+	v.Check(strings.TrimSpace(q) != "", "q", "must be provided")
+	v.Check(len(q) <= 100, "q", "must not be more than 100 bytes long")
+
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 20, "limit", "must not be more than 20")
+}
+
+// Suggest returns up to limit titles that complete q. Titles starting with q come first (served by
+// movies_title_prefix_idx), then titles containing it anywhere (served by movies_title_trgm_idx)
+func (m MovieModel) Suggest(ctx context.Context, q string, limit int) ([]*MovieSuggestion, error) {
+	query := `
+		SELECT id, title, year
+		FROM movies
+		WHERE deleted_at IS NULL
+		AND   (lower(title) LIKE $1 OR title ILIKE $2)
+		ORDER BY lower(title) LIKE $1 DESC, similarity(title, $3) DESC, title ASC, id ASC
+		LIMIT $4`
+
+	q = strings.TrimSpace(q)
+	escaped := escapeLike(strings.ToLower(q))
+
+	args := []any{escaped + "%", "%" + escaped + "%", q, limit}
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Search)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []*MovieSuggestion{}
+	for rows.Next() {
+		var suggestion MovieSuggestion
+		err := rows.Scan(&suggestion.ID, &suggestion.Title, &suggestion.Year)
+		if err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, &suggestion)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return suggestions, nil
+}
+
+// escapeLike escapes the LIKE wildcards so user input is always matched literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,101 @@
+package data
+
+import (
+	"context"
+	"fmt"
+)
+
+// SimilarWeights is how much each signal counts towards the score of a similar movie, they add up to 1.
+// Movies without reviews only score on the other three, which doesn't change how they rank
+var SimilarWeights = struct {
+	Genres   float64 // share of genres in common (jaccard index)
+	Year     float64 // 1 for the same year, halving at 10 years apart
+	Title    float64 // pg_trgm similarity of the titles
+	CoRating float64 // share of the movie's fans who are also fans of the other one
+}{0.45, 0.15, 0.15, 0.25}
+
+// similarFanRating is the rating a user has to give a movie to count as one of its fans
+const similarFanRating = 7
+
+// GetSimilar ranks the other movies by how much they look like the movie with the given id, best first.
+// Only movies sharing a genre, with a similar title or with fans in common are candidates
+func (m MovieModel) GetSimilar(ctx context.Context, id int, filters Filters) ([]*Movie, Metadata, error) {
+	var movie Movie
+
+	columns, dest := movieColumns(&movie, nil)
+
+	// wrapped so the score can be sorted on next to the id tiebreak
+	query := fmt.Sprintf(`
+		WITH source AS (
+			SELECT id, title, year, genres
+			FROM movies
+			WHERE id = $1 AND deleted_at IS NULL
+		), fans AS (
+			SELECT user_id FROM reviews WHERE movie_id = $1 AND rating >= $2
+		), co_rated AS (
+			SELECT reviews.movie_id, count(*) AS fans
+			FROM reviews
+			INNER JOIN fans ON fans.user_id = reviews.user_id
+			WHERE reviews.movie_id <> $1 AND reviews.rating >= $2
+			GROUP BY reviews.movie_id
+		)
+		SELECT count(*) OVER(), %s, score
+		FROM (
+			SELECT movies.*,
+			       $3 * cardinality(ARRAY(SELECT unnest(movies.genres) INTERSECT SELECT unnest(source.genres)))::float8
+			          / greatest(cardinality(ARRAY(SELECT unnest(movies.genres) UNION SELECT unnest(source.genres))), 1)
+			     + $4 / (1 + abs(movies.year - source.year) / 10.0)
+			     + $5 * similarity(movies.title, source.title)
+			     -- a handful of shared fans shouldn't weigh as much as hundreds, hence the + 10
+			     + $6 * coalesce(co_rated.fans, 0)::float8 / ((SELECT count(*) FROM fans) + 10) AS score
+			FROM movies
+			CROSS JOIN source
+			LEFT JOIN co_rated ON co_rated.movie_id = movies.id
+			WHERE movies.id <> source.id AND movies.deleted_at IS NULL
+			AND   (movies.genres && source.genres OR movies.title %% source.title OR co_rated.fans IS NOT NULL)
+		) AS similar
+		ORDER BY %s
+		LIMIT $7 OFFSET $8`, columns, filters.orderBy())
+
+	args := []any{
+		id,
+		similarFanRating,
+		SimilarWeights.Genres,
+		SimilarWeights.Year,
+		SimilarWeights.Title,
+		SimilarWeights.CoRating,
+		filters.limit(),
+		filters.offset(),
+	}
+
+	totalRecords := 0
+	dest = append([]any{&totalRecords}, dest...)
+	dest = append(dest, &movie.Score)
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Search)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+	for rows.Next() {
+		movie = Movie{}
+		err := rows.Scan(dest...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		scanned := movie
+		movies = append(movies, &scanned)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return movies, metadata, nil
+}
@@ -0,0 +1,98 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// MovieStore keeps the movie catalog, MovieModel is the postgres one and NewMemoryModels has one that
+// lives in memory. Both return the same errors for the same situations (ErrRecordNotFound,
+// ErrEditConflict, ErrDuplicateExternalID...), the conformance tests in stores_test.go hold them to it
+type MovieStore interface {
+	Insert(ctx context.Context, movie *Movie, userID int) error
+	Get(ctx context.Context, id int) (*Movie, error)
+	GetFields(ctx context.Context, id int, fields []string) (*Movie, error)
+	Update(ctx context.Context, movie *Movie, userID int) error
+	Delete(ctx context.Context, id int) error
+	GetAll(ctx context.Context, q MovieQuery) ([]*Movie, Metadata, error)
+	Suggest(ctx context.Context, q string, limit int) ([]*MovieSuggestion, error)
+	GetSimilar(ctx context.Context, id int, filters Filters) ([]*Movie, Metadata, error)
+
+	GetTrash(ctx context.Context, filters Filters) ([]*Movie, Metadata, error)
+	Restore(ctx context.Context, id int, userID int) (*Movie, error)
+	Purge(ctx context.Context, retention time.Duration) (int64, []string, error)
+
+	GetRevisions(ctx context.Context, movieID int, filters Filters) ([]*MovieRevision, Metadata, error)
+	GetRevision(ctx context.Context, movieID int, version int) (*MovieRevision, error)
+
+	SetImages(ctx context.Context, id int, images *MovieImages) (*MovieImages, error)
+
+	FindDuplicates(ctx context.Context, minSimilarity float64, filters Filters) ([]*DuplicateCandidate, Metadata, error)
+	Merge(ctx context.Context, target *Movie, duplicate *Movie, userID int) ([]string, error)
+
+	Export(ctx context.Context, q MovieQuery, fn func(*Movie) error) error
+	NewImport(ctx context.Context) (MovieImporter, error)
+}
+
+// MovieImporter inserts movies in batches, none of them are kept unless Commit is called. The movies of a
+// batch whose external ids are taken aren't inserted, InsertBatch returns their positions
+type MovieImporter interface {
+	InsertBatch(movies []*Movie, userID int) (taken []int, err error)
+	Commit() error
+	Rollback() error
+}
+
+type UserStore interface {
+	Insert(ctx context.Context, user *User) error
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	Update(ctx context.Context, user *User) error
+	GetForToken(ctx context.Context, scope string, tokenPlainText string) (*User, error)
+}
+
+type TokenStore interface {
+	New(ctx context.Context, userID int, ttl time.Duration, scope string) (*Token, error)
+	Insert(ctx context.Context, token *Token) error
+	DeleteAllForUser(ctx context.Context, scope string, userID int) error
+}
+
+type PermissionStore interface {
+	AddForUser(ctx context.Context, userID int, codes ...string) error
+	GetAllForUser(ctx context.Context, userID int) (Permissions, error)
+}
+
+// OutboxStore holds the emails waiting to be sent. Enqueue is meant to run in the same unit of work as
+// the change the email is about, so that one isn't kept without the other
+type OutboxStore interface {
+	Enqueue(ctx context.Context, email *OutboxEmail) error
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]*OutboxEmail, error)
+	MarkSent(ctx context.Context, email *OutboxEmail) error
+	MarkFailed(ctx context.Context, email *OutboxEmail, sendErr error, retry OutboxRetry) error
+	Get(ctx context.Context, id int) (*OutboxEmail, error)
+	GetAll(ctx context.Context, status string, filters Filters) ([]*OutboxEmail, Metadata, error)
+	Retry(ctx context.Context, id int) (*OutboxEmail, error)
+}
+
+// GenreStore is the genre taxonomy, the one in memory is what the genres migration seeds
+type GenreStore interface {
+	Resolver(ctx context.Context) (GenreResolver, error)
+	GetAll(ctx context.Context) ([]*Genre, error)
+}
+
+// TitleStore keeps the localized titles of the movies. There are none in memory: movies keep their
+// original title and adding one fails with ErrNoDatabase
+type TitleStore interface {
+	Put(ctx context.Context, title *LocalizedTitle) (created bool, err error)
+	Delete(ctx context.Context, movieID int, language string) error
+	GetAllForMovie(ctx context.Context, movieID int) ([]*LocalizedTitle, error)
+	Localize(ctx context.Context, movies []*Movie, languages []string) error
+}
+
+var (
+	_ MovieStore      = MovieModel{}
+	_ UserStore       = (*UserModel)(nil)
+	_ TokenStore      = (*TokenModel)(nil)
+	_ PermissionStore = (*PermissionsModel)(nil)
+	_ OutboxStore     = OutboxModel{}
+	_ GenreStore      = GenreModel{}
+	_ TitleStore      = TitleModel{}
+)
@@ -0,0 +1,1044 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"greenlight/internal/validator"
+)
+
+// The conformance suite: every store implementation has to pass the same tests, so the handlers can't
+// tell them apart. Each test gets empty stores to start from
+
+func TestMemoryStores(t *testing.T) {
+	testStores(t, func(t *testing.T) Models {
+		return NewMemoryModels()
+	})
+}
+
+// the models with no memory version fail cleanly instead of on a nil database
+func TestMemoryModelsWithoutDatabase(t *testing.T) {
+	models := NewMemoryModels()
+
+	_, err := models.People.Get(context.Background(), 1)
+	if !errors.Is(err, ErrNoDatabase) {
+		t.Errorf("got %v getting a person, want ErrNoDatabase", err)
+	}
+	_, _, err = models.Reviews.GetAllForMovie(context.Background(), 1, Filters{Page: 1, PageSize: 20, Sort: "id", SortSafeList: []string{"id"}})
+	if !errors.Is(err, ErrNoDatabase) {
+		t.Errorf("got %v listing reviews, want ErrNoDatabase", err)
+	}
+	err = models.Watchlist.Add(context.Background(), 1, 1)
+	if !errors.Is(err, ErrNoDatabase) {
+		t.Errorf("got %v adding to a watchlist, want ErrNoDatabase", err)
+	}
+	_, err = models.Titles.Put(context.Background(), &LocalizedTitle{MovieID: 1, Language: "es", Title: "Título"})
+	if !errors.Is(err, ErrNoDatabase) {
+		t.Errorf("got %v adding a title, want ErrNoDatabase", err)
+	}
+}
+
+// TestPostgresStores runs the suite against the database in GREENLIGHT_TEST_DB_DSN. It has to be migrated
+// and it's emptied before every test, never point it at one whose data matters
+func TestPostgresStores(t *testing.T) {
+	testStores(t, postgresModels(t))
+}
+
+// postgresModels connects to the database in GREENLIGHT_TEST_DB_DSN, skipping the test when it isn't
+// set. Every call of the function it returns empties the database and hands out new models
+func postgresModels(t *testing.T) func(t *testing.T) Models {
+	dsn := os.Getenv("GREENLIGHT_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("GREENLIGHT_TEST_DB_DSN is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return func(t *testing.T) Models {
+		// everything else that holds rows references movies or users, CASCADE empties it as well
+		_, err := db.Exec(`TRUNCATE movies, users, email_outbox RESTART IDENTITY CASCADE`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return NewModels(db, DefaultTimeouts)
+	}
+}
+
+func testStores(t *testing.T, newModels func(t *testing.T) Models) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, models Models)
+	}{
+		{"MovieInsertAndGet", testMovieInsertAndGet},
+		{"MovieEditConflict", testMovieEditConflict},
+		{"MovieDuplicateExternalID", testMovieDuplicateExternalID},
+		{"MovieImportExternalIDs", testMovieImportExternalIDs},
+		{"MovieTrash", testMovieTrash},
+		{"MovieMergeImages", testMovieMergeImages},
+		{"MovieFiltering", testMovieFiltering},
+		{"MovieHighlightEscaped", testMovieHighlightEscaped},
+		{"UserDuplicateEmail", testUserDuplicateEmail},
+		{"UserEditConflict", testUserEditConflict},
+		{"TokenScopeAndExpiry", testTokenScopeAndExpiry},
+		{"Permissions", testPermissions},
+		{"Genres", testGenres},
+		{"CanceledContext", testCanceledContext},
+		{"UnitOfWork", testUnitOfWork},
+		{"NestedUnitOfWork", testNestedUnitOfWork},
+		{"OutboxEnqueue", testOutboxEnqueue},
+		{"OutboxDelivery", testOutboxDelivery},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newModels(t))
+		})
+	}
+}
+
+func insertMovie(t *testing.T, models Models, title string, year int, genres ...string) *Movie {
+	t.Helper()
+
+	movie := &Movie{Title: title, Year: year, Runtime: 100, Genres: genres}
+	err := models.Movies.Insert(context.Background(), movie, 0)
+	if err != nil {
+		t.Fatalf("inserting %q: %v", title, err)
+	}
+	return movie
+}
+
+func insertUser(t *testing.T, models Models, email string) *User {
+	t.Helper()
+
+	// a real bcrypt hash isn't needed, the stores only keep it
+	user := &User{Name: "test", Email: email, Password: password{hash: []byte("hash of " + email)}}
+	err := models.Users.Insert(context.Background(), user)
+	if err != nil {
+		t.Fatalf("inserting %q: %v", email, err)
+	}
+	return user
+}
+
+func movieTitles(movies []*Movie) []string {
+	titles := []string{}
+	for _, movie := range movies {
+		titles = append(titles, movie.Title)
+	}
+	return titles
+}
+
+func testMovieInsertAndGet(t *testing.T, models Models) {
+	ctx := context.Background()
+
+	movie := &Movie{
+		Title:       "Moana",
+		Year:        2016,
+		Runtime:     107,
+		Genres:      []string{"animation", "adventure"},
+		ExternalIDs: ExternalIDs{"imdb": "tt3521164"},
+	}
+	err := models.Movies.Insert(ctx, movie, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if movie.ID < 1 || movie.Version != 1 || movie.CreatedAt.IsZero() {
+		t.Fatalf("got id %d, version %d, created at %v after insert", movie.ID, movie.Version, movie.CreatedAt)
+	}
+
+	got, err := models.Movies.Get(ctx, movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != movie.Title || got.Year != movie.Year || got.Runtime != movie.Runtime || got.Version != 1 ||
+		!slices.Equal(got.Genres, movie.Genres) || !maps.Equal(got.ExternalIDs, movie.ExternalIDs) {
+		t.Errorf("got %+v, want %+v", got, movie)
+	}
+
+	// the caller's slice isn't shared with the store
+	movie.Genres[0] = "changed"
+	got, _ = models.Movies.Get(ctx, movie.ID)
+	if got.Genres[0] != "animation" {
+		t.Errorf("got genres %v after changing the inserted movie", got.Genres)
+	}
+
+	got, err = models.Movies.GetFields(ctx, movie.ID, []string{"title", "year"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "Moana" || got.Year != 2016 || got.ID != 0 || got.Runtime != 0 || got.Genres != nil {
+		t.Errorf("got %+v, want only the title and year", got)
+	}
+
+	for _, id := range []int{0, movie.ID + 1} {
+		_, err = models.Movies.Get(ctx, id)
+		if !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("got %v for movie %d, want ErrRecordNotFound", err, id)
+		}
+	}
+}
+
+func testMovieEditConflict(t *testing.T, models Models) {
+	ctx := context.Background()
+
+	movie := insertMovie(t, models, "Moana", 2016, "animation")
+	stale := *movie
+
+	movie.Title = "Moana 2"
+	err := models.Movies.Update(ctx, movie, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if movie.Version != 2 {
+		t.Errorf("got version %d after update, want 2", movie.Version)
+	}
+
+	stale.Title = "Vaiana"
+	err = models.Movies.Update(ctx, &stale, 0)
+	if !errors.Is(err, ErrEditConflict) {
+		t.Fatalf("got %v updating a stale version, want ErrEditConflict", err)
+	}
+
+	got, _ := models.Movies.Get(ctx, movie.ID)
+	if got.Title != "Moana 2" || got.Version != 2 {
+		t.Errorf("got %q version %d, the stale update must not apply", got.Title, got.Version)
+	}
+
+	filters := Filters{Page: 1, PageSize: 10, Sort: "-version", SortSafeList: []string{"version", "-version"}}
+	revisions, metadata, err := models.Movies.GetRevisions(ctx, movie.ID, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 || metadata.TotalRecords != 2 || revisions[0].Version != 2 || revisions[1].Title != "Moana" {
+		t.Errorf("got revisions %+v, want versions 2 and 1", revisions)
+	}
+
+	first, err := models.Movies.GetRevision(ctx, movie.ID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Title != "Moana" {
+		t.Errorf("got %q for the first revision, want Moana", first.Title)
+	}
+}
+
+func testMovieDuplicateExternalID(t *testing.T, models Models) {
+	ctx := context.Background()
+
+	moana := &Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}, ExternalIDs: ExternalIDs{"imdb": "tt3521164"}}
+	err := models.Movies.Insert(ctx, moana, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	copycat := &Movie{Title: "Vaiana", Year: 2016, Runtime: 107, Genres: []string{"animation"}, ExternalIDs: ExternalIDs{"imdb": "tt3521164"}}
+	err = models.Movies.Insert(ctx, copycat, 0)
+	if !errors.Is(err, ErrDuplicateExternalID) {
+		t.Fatalf("got %v inserting a taken imdb id, want ErrDuplicateExternalID", err)
+	}
+
+	copycat.ExternalIDs = nil
+	err = models.Movies.Insert(ctx, copycat, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	copycat.ExternalIDs = ExternalIDs{"imdb": "tt3521164"}
+	err = models.Movies.Update(ctx, copycat, 0)
+	if !errors.Is(err, ErrDuplicateExternalID) {
+		t.Fatalf("got %v updating to a taken imdb id, want ErrDuplicateExternalID", err)
+	}
+
+	// a movie keeps its own ids when updated
+	moana.Runtime = 103
+	err = models.Movies.Update(ctx, moana, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testMovieImportExternalIDs(t *testing.T, models Models) {
+	ctx := context.Background()
+
+	moana := &Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}, ExternalIDs: ExternalIDs{"imdb": "tt3521164"}}
+	err := models.Movies.Insert(ctx, moana, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	movieImport, err := models.Movies.NewImport(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer movieImport.Rollback()
+
+	batch := []*Movie{
+		{Title: "Vaiana", Year: 2016, Runtime: 107, ExternalIDs: ExternalIDs{"imdb": "tt3521164", "tmdb": "277834"}},
+		{Title: "The Matrix", Year: 1999, Runtime: 136, ExternalIDs: ExternalIDs{"imdb": "tt0133093", "tmdb": "603"}},
+		{Title: "Matrix", Year: 1999, Runtime: 136, ExternalIDs: ExternalIDs{"tmdb": "603"}},
+		{Title: "Heat", Year: 1995, Runtime: 170},
+	}
+	taken, err := movieImport.InsertBatch(batch, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the taken imdb id and the repeated tmdb id of the batch
+	if !slices.Equal(taken, []int{0, 2}) {
+		t.Errorf("got %v taken, want the first and third movies", taken)
+	}
+
+	// the earlier batches of the import count too
+	taken, err = movieImport.InsertBatch([]*Movie{{Title: "The Matrix", Year: 1999, Runtime: 136, ExternalIDs: ExternalIDs{"imdb": "tt0133093"}}}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(taken, []int{0}) {
+		t.Errorf("got %v taken, want the movie of an earlier batch clashing", taken)
+	}
+
+	err = movieImport.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	matrix, err := models.Movies.Get(ctx, batch[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(matrix.ExternalIDs, batch[1].ExternalIDs) {
+		t.Errorf("got external ids %v, want %v", matrix.ExternalIDs, batch[1].ExternalIDs)
+	}
+	if batch[0].ID != 0 || batch[2].ID != 0 || batch[3].ID == 0 {
+		t.Errorf("got ids %d, %d and %d, want only the movie without external ids inserted", batch[0].ID, batch[2].ID, batch[3].ID)
+	}
+}
+
+// setPoster gives a movie a poster with one thumbnail, stored under name/
+func setPoster(t *testing.T, models Models, movieID int, name string) *MovieImages {
+	t.Helper()
+
+	images := &MovieImages{
+		Poster:     Image{Key: name + "/poster.jpg", URL: "/images/" + name + "/poster.jpg", Width: 600, Height: 900},
+		Thumbnails: map[string]Image{"small": {Key: name + "/small.jpg", URL: "/images/" + name + "/small.jpg", Width: 100, Height: 150}},
+	}
+	_, err := models.Movies.SetImages(context.Background(), movieID, images)
+	if err != nil {
+		t.Fatalf("setting the poster of %d: %v", movieID, err)
+	}
+	return images
+}
+
+func testMovieTrash(t *testing.T, models Models) {
+	ctx := context.Background()
+
+	movie := insertMovie(t, models, "Moana", 2016, "animation")
+	setPoster(t, models, movie.ID, "moana")
+
+	err := models.Movies.Delete(ctx, movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = models.Movies.Get(ctx, movie.ID)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got %v getting a deleted movie, want ErrRecordNotFound", err)
+	}
+	err = models.Movies.Delete(ctx, movie.ID)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got %v deleting a movie twice, want ErrRecordNotFound", err)
+	}
+	err = models.Movies.Update(ctx, movie, 0)
+	if !errors.Is(err, ErrEditConflict) {
+		t.Errorf("got %v updating a deleted movie, want ErrEditConflict", err)
+	}
+
+	filters := Filters{Page: 1, PageSize: 10, Sort: "id", SortSafeList: []string{"id"}}
+	trash, _, err := models.Movies.GetTrash(ctx, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trash) != 1 || trash[0].ID != movie.ID || trash[0].DeletedAt.IsZero() {
+		t.Fatalf("got trash %+v, want the deleted movie", trash)
+	}
+
+	restored, err := models.Movies.Restore(ctx, movie.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Version != 2 {
+		t.Errorf("got version %d after restoring, want 2", restored.Version)
+	}
+	_, err = models.Movies.Restore(ctx, movie.ID, 0)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got %v restoring a movie that isn't in the trash, want ErrRecordNotFound", err)
+	}
+
+	err = models.Movies.Delete(ctx, movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	purged, keys, err := models.Movies.Purge(ctx, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 0 || len(keys) != 0 {
+		t.Errorf("purged %d movies (images %v) deleted just now with an hour of retention", purged, keys)
+	}
+
+	// with a negative retention everything in the trash is old enough
+	purged, keys, err = models.Movies.Purge(ctx, -time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 1 {
+		t.Errorf("purged %d movies, want 1", purged)
+	}
+	slices.Sort(keys)
+	if !slices.Equal(keys, []string{"moana/poster.jpg", "moana/small.jpg"}) {
+		t.Errorf("got image keys %v, want the purged movie's", keys)
+	}
+
+	_, err = models.Movies.Restore(ctx, movie.ID, 0)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got %v restoring a purged movie, want ErrRecordNotFound", err)
+	}
+}
+
+func testMovieMergeImages(t *testing.T, models Models) {
+	ctx := context.Background()
+
+	target := insertMovie(t, models, "Moana", 2016, "animation")
+	duplicate := insertMovie(t, models, "Moana ", 2016, "adventure")
+	setPoster(t, models, target.ID, "target")
+	kept := setPoster(t, models, duplicate.ID, "duplicate")
+
+	// the caller decided the duplicate's poster wins
+	target.Images = kept
+	unused, err := models.Movies.Merge(ctx, target, duplicate, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slices.Sort(unused)
+	if !slices.Equal(unused, []string{"target/poster.jpg", "target/small.jpg"}) {
+		t.Errorf("got unused image keys %v, want the target's old ones", unused)
+	}
+
+	got, err := models.Movies.Get(ctx, target.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Images == nil || got.Images.Poster.Key != "duplicate/poster.jpg" {
+		t.Errorf("got images %+v, want the duplicate's", got.Images)
+	}
+}
+
+func testMovieFiltering(t *testing.T, models Models) {
+	ctx := context.Background()
+
+	insertMovie(t, models, "Black Panther", 2018, "action", "adventure")
+	insertMovie(t, models, "Black Swan", 2010, "drama", "thriller")
+	lionKing := insertMovie(t, models, "The Lion King", 1994, "animation", "drama")
+
+	filters := Filters{Page: 1, PageSize: 2, Sort: "-year", SortSafeList: []string{"year", "-year", "relevance"}}
+
+	tests := []struct {
+		name  string
+		query MovieQuery
+		want  []string
+		total int
+	}{
+		{"first page", MovieQuery{}, []string{"Black Panther", "Black Swan"}, 3},
+		{"title", MovieQuery{Title: "black"}, []string{"Black Panther", "Black Swan"}, 2},
+		{"genres", MovieQuery{Genres: []string{"drama"}}, []string{"Black Swan", "The Lion King"}, 2},
+		{"title and genres", MovieQuery{Title: "black", Genres: []string{"drama"}}, []string{"Black Swan"}, 1},
+		{"every genre", MovieQuery{Genres: []string{"drama", "animation"}}, []string{"The Lion King"}, 1},
+		{"fuzzy prefix", MovieQuery{Title: "pant", Search: SearchFuzzy}, []string{"Black Panther"}, 1},
+		{"no match", MovieQuery{Title: "moana"}, []string{}, 0},
+	}
+
+	for _, tt := range tests {
+		tt.query.Filters = filters
+
+		movies, metadata, err := models.Movies.GetAll(ctx, tt.query)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !slices.Equal(movieTitles(movies), tt.want) || metadata.TotalRecords != tt.total {
+			t.Errorf("%s: got %v of %d, want %v of %d", tt.name, movieTitles(movies), metadata.TotalRecords, tt.want, tt.total)
+		}
+		if tt.query.Title != "" && len(movies) > 0 && movies[0].Relevance <= 0 {
+			t.Errorf("%s: got relevance %v, want it above zero", tt.name, movies[0].Relevance)
+		}
+	}
+
+	filters.Page = 2
+	movies, metadata, err := models.Movies.GetAll(ctx, MovieQuery{Filters: filters, Fields: []string{"title"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(movieTitles(movies), []string{"The Lion King"}) || metadata.LastPage != 2 || metadata.CurrentPage != 2 {
+		t.Errorf("got %v, %+v for the second page", movieTitles(movies), metadata)
+	}
+	if len(movies) == 1 && (movies[0].ID != 0 || movies[0].Year != 0) {
+		t.Errorf("got %+v, want only the title", movies[0])
+	}
+
+	// deleted movies are left out of listings
+	err = models.Movies.Delete(ctx, lionKing.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filters.Page = 1
+	movies, _, err = models.Movies.GetAll(ctx, MovieQuery{Filters: filters, Genres: []string{"drama"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(movieTitles(movies), []string{"Black Swan"}) {
+		t.Errorf("got %v, the deleted movie must not be listed", movieTitles(movies))
+	}
+}
+
+func testMovieHighlightEscaped(t *testing.T, models Models) {
+	ctx := context.Background()
+
+	insertMovie(t, models, `Tom & Jerry <img onerror="x">`, 1992)
+
+	movies, _, err := models.Movies.GetAll(ctx, MovieQuery{
+		Title:     "jerry",
+		Highlight: &Highlight{StartSel: "<mark>", StopSel: "</mark>"},
+		Filters:   Filters{Page: 1, PageSize: 10, Sort: "id", SortSafeList: []string{"id"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(movies) != 1 {
+		t.Fatalf("got %v, want the movie", movieTitles(movies))
+	}
+
+	// the highlight tags are the only markup left
+	want := `Tom &amp; <mark>Jerry</mark> &lt;img onerror=&#34;x&#34;&gt;`
+	if movies[0].HighlightedTitle != want {
+		t.Errorf("got highlighted title %q, want %q", movies[0].HighlightedTitle, want)
+	}
+}
+
+func testUserDuplicateEmail(t *testing.T, models Models) {
+	ctx := context.Background()
+
+	alice := insertUser(t, models, "alice@example.com")
+	if alice.ID < 1 || alice.Version != 1 {
+		t.Fatalf("got id %d, version %d after insert", alice.ID, alice.Version)
+	}
+
+	// emails are compared ignoring case
+	for _, email := range []string{"alice@example.com", "Alice@Example.com"} {
+		err := models.Users.Insert(ctx, &User{Name: "test", Email: email, Password: password{hash: []byte("x")}})
+		if !errors.Is(err, ErrDuplicateEmail) {
+			t.Errorf("got %v inserting %s, want ErrDuplicateEmail", err, email)
+		}
+	}
+
+	got, err := models.Users.GetByEmail(ctx, "ALICE@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != alice.ID || string(got.Password.hash) != "hash of alice@example.com" {
+		t.Errorf("got %+v, want alice", got)
+	}
+
+	_, err = models.Users.GetByEmail(ctx, "bob@example.com")
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got %v for an unknown email, want ErrRecordNotFound", err)
+	}
+
+	bob := insertUser(t, models, "bob@example.com")
+	bob.Email = "alice@example.com"
+	err = models.Users.Update(ctx, bob)
+	if !errors.Is(err, ErrDuplicateEmail) {
+		t.Errorf("got %v updating to a taken email, want ErrDuplicateEmail", err)
+	}
+}
+
+func testUserEditConflict(t *testing.T, models Models) {
+	ctx := context.Background()
+
+	user := insertUser(t, models, "alice@example.com")
+	stale := *user
+
+	user.Ativated = true
+	err := models.Users.Update(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Version != 2 {
+		t.Errorf("got version %d after update, want 2", user.Version)
+	}
+
+	stale.Name = "stale"
+	err = models.Users.Update(ctx, &stale)
+	if !errors.Is(err, ErrEditConflict) {
+		t.Fatalf("got %v updating a stale version, want ErrEditConflict", err)
+	}
+
+	got, _ := models.Users.GetByEmail(ctx, user.Email)
+	if !got.Ativated || got.Name != "test" {
+		t.Errorf("got %+v, the stale update must not apply", got)
+	}
+}
+
+func testTokenScopeAndExpiry(t *testing.T, models Models) {
+	ctx := context.Background()
+
+	user := insertUser(t, models, "alice@example.com")
+
+	activation, err := models.Tokens.New(ctx, user.ID, time.Hour, ScopeActivation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authentication, err := models.Tokens.New(ctx, user.ID, time.Hour, ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := models.Tokens.New(ctx, user.ID, -time.Hour, ScopeActivation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := models.Users.GetForToken(ctx, ScopeActivation, activation.Plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != user.ID {
+		t.Errorf("got user %d for the token, want %d", got.ID, user.ID)
+	}
+
+	notFound := []struct {
+		name      string
+		scope     string
+		plaintext string
+	}{
+		{"wrong scope", ScopeAuthentication, activation.Plaintext},
+		{"expired", ScopeActivation, expired.Plaintext},
+		{"unknown", ScopeActivation, "AAAAAAAAAAAAAAAAAAAAAAAAAA"},
+	}
+	for _, tt := range notFound {
+		_, err := models.Users.GetForToken(ctx, tt.scope, tt.plaintext)
+		if !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("%s: got %v, want ErrRecordNotFound", tt.name, err)
+		}
+	}
+
+	// only the tokens of the given scope are deleted
+	err = models.Tokens.DeleteAllForUser(ctx, ScopeActivation, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = models.Users.GetForToken(ctx, ScopeActivation, activation.Plaintext)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got %v for a deleted token, want ErrRecordNotFound", err)
+	}
+	_, err = models.Users.GetForToken(ctx, ScopeAuthentication, authentication.Plaintext)
+	if err != nil {
+		t.Errorf("got %v for a token of another scope, want it kept", err)
+	}
+}
+
+func testPermissions(t *testing.T, models Models) {
+	ctx := context.Background()
+
+	alice := insertUser(t, models, "alice@example.com")
+	bob := insertUser(t, models, "bob@example.com")
+
+	permissions, err := models.Permissions.GetAllForUser(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(permissions) != 0 {
+		t.Errorf("got %v for a new user, want no permissions", permissions)
+	}
+
+	err = models.Permissions.AddForUser(ctx, alice.ID, "movies:read", "movies:write")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	permissions, err = models.Permissions.GetAllForUser(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(permissions)
+	if !slices.Equal(permissions, Permissions{"movies:read", "movies:write"}) {
+		t.Errorf("got %v, want movies:read and movies:write", permissions)
+	}
+
+	permissions, err = models.Permissions.GetAllForUser(ctx, bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if permissions.Includes("movies:read") {
+		t.Errorf("got %v for another user, want no permissions", permissions)
+	}
+}
+
+func testGenres(t *testing.T, models Models) {
+	ctx := context.Background()
+
+	resolver, err := models.Genres.Resolver(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := validator.New()
+	got := resolver.Normalize(v, []string{"Sci-Fi", "science  fiction", "Science-Fiction", "HORROR", "historical"})
+	want := []string{"science-fiction", "science-fiction", "science-fiction", "horror", "history"}
+	if !v.Valid() || !slices.Equal(got, want) {
+		t.Errorf("got %q (errors %v), want %q", got, v.Errors, want)
+	}
+
+	v = validator.New()
+	resolver.Normalize(v, []string{"drama", "space opera"})
+	if v.Errors["genres"] != "unknown genre space opera" {
+		t.Errorf("got errors %v, want space opera reported", v.Errors)
+	}
+
+	insertMovie(t, models, "Alien", 1979, "science-fiction", "horror")
+	trashed := insertMovie(t, models, "The Thing", 1982, "horror")
+	err = models.Movies.Delete(ctx, trashed.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	genres, err := models.Genres.GetAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := make(map[string]int)
+	for _, genre := range genres {
+		counts[genre.Slug] = genre.Movies
+		if genre.Slug == "science-fiction" && !slices.Equal(genre.Aliases, []string{"sci-fi", "scifi", "sf"}) {
+			t.Errorf("got aliases %q for science-fiction", genre.Aliases)
+		}
+	}
+	// movies in the trash don't count
+	if counts["science-fiction"] != 1 || counts["horror"] != 1 || counts["drama"] != 0 {
+		t.Errorf("got counts %v, want one science-fiction and one horror movie", counts)
+	}
+}
+
+func testCanceledContext(t *testing.T, models Models) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := models.Movies.Get(ctx, 1)
+	if !errors.Is(err, ErrQueryCanceled) {
+		t.Errorf("got %v getting a movie, want ErrQueryCanceled", err)
+	}
+
+	_, err = models.Users.GetByEmail(ctx, "alice@example.com")
+	if !errors.Is(err, ErrQueryCanceled) {
+		t.Errorf("got %v getting a user, want ErrQueryCanceled", err)
+	}
+}
+
+func testUnitOfWork(t *testing.T, models Models) {
+	ctx := context.Background()
+	errFailed := errors.New("failed")
+
+	err := models.InTx(ctx, func(models Models) error {
+		user := insertUser(t, models, "alice@example.com")
+		insertMovie(t, models, "Moana", 2016, "animation")
+
+		err := models.Permissions.AddForUser(ctx, user.ID, "movies:read")
+		if err != nil {
+			return err
+		}
+		return errFailed
+	})
+	if !errors.Is(err, errFailed) {
+		t.Fatalf("got %v, want the error fn returned", err)
+	}
+
+	// nothing of the failed unit is left
+	_, err = models.Users.GetByEmail(ctx, "alice@example.com")
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got %v for the user of a failed unit of work, want ErrRecordNotFound", err)
+	}
+	movies, _, err := models.Movies.GetAll(ctx, MovieQuery{Filters: Filters{Page: 1, PageSize: 10, Sort: "id", SortSafeList: []string{"id"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(movies) != 0 {
+		t.Errorf("got %v, want the movie of the failed unit of work gone", movieTitles(movies))
+	}
+
+	var bob *User
+	err = models.InTx(ctx, func(models Models) error {
+		bob = insertUser(t, models, "bob@example.com")
+
+		// a nested unit is part of the one running, its changes are seen right away
+		return models.InTx(ctx, func(models Models) error {
+			_, err := models.Users.GetByEmail(ctx, "bob@example.com")
+			if err != nil {
+				return err
+			}
+			return models.Permissions.AddForUser(ctx, bob.ID, "movies:read")
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	permissions, err := models.Permissions.GetAllForUser(ctx, bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !permissions.Includes("movies:read") {
+		t.Errorf("got %v after the unit of work, want movies:read", permissions)
+	}
+}
+
+// a nested unit has no commit or rollback of its own, whatever it did stays or goes with the outer one
+func testNestedUnitOfWork(t *testing.T, models Models) {
+	ctx := context.Background()
+	errFailed := errors.New("failed")
+
+	err := models.InTx(ctx, func(models Models) error {
+		insertUser(t, models, "alice@example.com")
+
+		err := models.InTx(ctx, func(models Models) error {
+			insertMovie(t, models, "Moana", 2016, "animation")
+			return errFailed
+		})
+		if !errors.Is(err, errFailed) {
+			t.Errorf("got %v from the nested unit, want the error its fn returned", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = models.Users.GetByEmail(ctx, "alice@example.com")
+	if err != nil {
+		t.Errorf("got %v getting the user of the outer unit", err)
+	}
+	_, err = models.Movies.Get(ctx, 1)
+	if err != nil {
+		t.Errorf("got %v getting the movie of the nested unit, want it kept with the outer one", err)
+	}
+
+	// and the outer unit failing takes the nested one with it
+	err = models.InTx(ctx, func(models Models) error {
+		err := models.InTx(ctx, func(models Models) error {
+			insertUser(t, models, "bob@example.com")
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return errFailed
+	})
+	if !errors.Is(err, errFailed) {
+		t.Fatalf("got %v, want the error fn returned", err)
+	}
+
+	_, err = models.Users.GetByEmail(ctx, "bob@example.com")
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got %v for the user of the failed outer unit, want ErrRecordNotFound", err)
+	}
+}
+
+func enqueueEmail(t *testing.T, models Models, key string) *OutboxEmail {
+	t.Helper()
+
+	email := &OutboxEmail{
+		IdempotencyKey: key,
+		Recipient:      "alice@example.com",
+		Template:       "user_welcome.tmpl",
+		Data:           map[string]any{"activationToken": "AAAAAAAAAAAAAAAAAAAAAAAAAA", "userID": 1000000},
+	}
+	err := models.Outbox.Enqueue(context.Background(), email)
+	if err != nil {
+		t.Fatalf("enqueuing %q: %v", key, err)
+	}
+	return email
+}
+
+func testOutboxEnqueue(t *testing.T, models Models) {
+	ctx := context.Background()
+	errFailed := errors.New("failed")
+
+	email := enqueueEmail(t, models, "welcome:1")
+	if email.ID < 1 || email.Status != OutboxPending {
+		t.Fatalf("got id %d, status %q after enqueue", email.ID, email.Status)
+	}
+
+	// the same key again is a no-op
+	again := enqueueEmail(t, models, "welcome:1")
+	if again.ID != 0 {
+		t.Errorf("got id %d for a repeated key, want 0", again.ID)
+	}
+
+	got, err := models.Outbox.Get(ctx, email.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Recipient != "alice@example.com" || got.Attempts != 0 {
+		t.Errorf("got %+v", got)
+	}
+	if userID := got.Data["userID"]; fmt.Sprint(userID) != "1000000" {
+		t.Errorf("got userID %v, want it to read back as 1000000", userID)
+	}
+
+	// the email goes away with the unit of work it was queued in
+	err = models.InTx(ctx, func(models Models) error {
+		enqueueEmail(t, models, "welcome:2")
+		return errFailed
+	})
+	if !errors.Is(err, errFailed) {
+		t.Fatalf("got %v, want the error fn returned", err)
+	}
+
+	emails, metadata, err := models.Outbox.GetAll(ctx, "", Filters{Page: 1, PageSize: 10, Sort: "id", SortSafeList: []string{"id"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(emails) != 1 || metadata.TotalRecords != 1 {
+		t.Errorf("got %d emails (%d in total), want only the first one", len(emails), metadata.TotalRecords)
+	}
+}
+
+func testOutboxDelivery(t *testing.T, models Models) {
+	ctx := context.Background()
+	retry := OutboxRetry{MaxAttempts: 2, Backoff: time.Hour, MaxBackoff: 2 * time.Hour}
+	errSMTP := errors.New("smtp: connection refused")
+
+	first := enqueueEmail(t, models, "welcome:1")
+	second := enqueueEmail(t, models, "welcome:2")
+
+	// a lease that already ran out stands in for a worker that died mid send
+	claimed, err := models.Outbox.Claim(ctx, 1, -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 || claimed[0].ID != first.ID || claimed[0].Attempts != 1 {
+		t.Fatalf("got %+v, want the first email on its first attempt", claimed)
+	}
+	stale := claimed[0]
+
+	claimed, err = models.Outbox.Claim(ctx, 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 2 {
+		t.Fatalf("got %d emails, want both", len(claimed))
+	}
+
+	// the first claim lost the email to the second one, it can't mark it anymore
+	err = models.Outbox.MarkSent(ctx, stale)
+	if !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("got %v marking an email sent under a lost lease, want ErrLeaseLost", err)
+	}
+	err = models.Outbox.MarkFailed(ctx, stale, errSMTP, retry)
+	if !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("got %v marking an email failed under a lost lease, want ErrLeaseLost", err)
+	}
+
+	// leased emails aren't handed out again
+	leased, err := models.Outbox.Claim(ctx, 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leased) != 0 {
+		t.Errorf("got %d emails while they're leased, want none", len(leased))
+	}
+	_, err = models.Outbox.Retry(ctx, first.ID)
+	if !errors.Is(err, ErrEditConflict) {
+		t.Errorf("got %v retrying a leased email, want ErrEditConflict", err)
+	}
+
+	for _, email := range claimed {
+		switch email.ID {
+		case first.ID:
+			err = models.Outbox.MarkFailed(ctx, email, errSMTP, retry)
+		case second.ID:
+			err = models.Outbox.MarkSent(ctx, email)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := models.Outbox.Get(ctx, first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != OutboxDead || got.Attempts != 2 || got.LastError != errSMTP.Error() {
+		t.Errorf("got %+v, want it dead after its last attempt", got)
+	}
+
+	got, err = models.Outbox.Get(ctx, second.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != OutboxSent || got.SentAt.IsZero() || got.Data != nil {
+		t.Errorf("got %+v, want it sent with its data dropped", got)
+	}
+	_, err = models.Outbox.Retry(ctx, second.ID)
+	if !errors.Is(err, ErrAlreadySent) {
+		t.Errorf("got %v retrying a sent email, want ErrAlreadySent", err)
+	}
+
+	emails, _, err := models.Outbox.GetAll(ctx, OutboxDead, Filters{Page: 1, PageSize: 10, Sort: "id", SortSafeList: []string{"id"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(emails) != 1 || emails[0].ID != first.ID {
+		t.Errorf("got %+v, want only the dead email", emails)
+	}
+
+	// a retried email goes out right away with all its attempts ahead, a failure then backs off
+	retried, err := models.Outbox.Retry(ctx, first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retried.Status != OutboxPending || retried.Attempts != 0 {
+		t.Errorf("got %+v after retrying", retried)
+	}
+
+	claimed, err = models.Outbox.Claim(ctx, 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 || claimed[0].Data["activationToken"] == nil {
+		t.Fatalf("got %+v, want the retried email with its data", claimed)
+	}
+	err = models.Outbox.MarkFailed(ctx, claimed[0], errSMTP, retry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claimed[0].Status != OutboxPending || time.Until(claimed[0].NextAttemptAt) < 59*time.Minute {
+		t.Errorf("got %+v, want it pending for another hour", claimed[0])
+	}
+
+	claimed, err = models.Outbox.Claim(ctx, 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 0 {
+		t.Errorf("got %d emails before their next attempt, want none", len(claimed))
+	}
+}
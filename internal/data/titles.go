@@ -0,0 +1,266 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"greenlight/internal/validator"
+
+	"github.com/lib/pq"
+	"golang.org/x/text/language"
+)
+
+// DefaultTitleLanguage is the language movies.title is taken to be in, every other language falls back to it
+const DefaultTitleLanguage = "en"
+
+// TitleSearchConfigs maps a base language to the text search config its titles are indexed with,
+// languages missing here use 'simple' (no stemming and no stop words)
+var TitleSearchConfigs = map[string]string{
+	"da": "danish",
+	"de": "german",
+	"en": "english",
+	"es": "spanish",
+	"fi": "finnish",
+	"fr": "french",
+	"hu": "hungarian",
+	"it": "italian",
+	"nb": "norwegian",
+	"nl": "dutch",
+	"no": "norwegian",
+	"pt": "portuguese",
+	"ro": "romanian",
+	"ru": "russian",
+	"sv": "swedish",
+	"tr": "turkish",
+}
+
+// LocalizedTitle is the title a movie goes by in a language or region
+type LocalizedTitle struct {
+	ID       int    `json:"id"`
+	MovieID  int    `json:"movie_id"`
+	Language string `json:"language"`
+	Title    string `json:"title"`
+}
+
+type TitleModel struct {
+	DB *DB
+}
+
+// ParseLanguage canonicalizes a language tag, e.g. "pt-br" becomes "pt-BR". ok is false for
+// anything that isn't a tag with a known base language
+func ParseLanguage(s string) (tag string, ok bool) {
+	t, err := language.Parse(s)
+	if err != nil {
+		return "", false
+	}
+
+	base, _, _ := t.Raw()
+	if base.String() == "und" {
+		return "", false
+	}
+
+	return t.String(), true
+}
+
+// PreferredLanguages turns the tags a client asked for, best first, into the languages titles are
+// matched against. Every tag is followed by its base language, so "pt-BR" also takes a "pt" title.
+// The list stops at the default language since movies.title is already in it, only a regional
+// title (e.g. "en-GB") can still be better
+func PreferredLanguages(tags []language.Tag) []string {
+	languages := []string{}
+
+	for _, tag := range tags {
+		b, _, _ := tag.Raw()
+		base := b.String()
+		// und is an unknown language, mul the "*" of an Accept-Language header, neither picks a title
+		if base == "und" || base == "mul" {
+			continue
+		}
+
+		if base == DefaultTitleLanguage {
+			if tag.String() != base {
+				languages = append(languages, tag.String())
+			}
+			break
+		}
+
+		for _, lang := range []string{tag.String(), base} {
+			if !slices.Contains(languages, lang) {
+				languages = append(languages, lang)
+			}
+		}
+	}
+
+	return languages
+}
+
+// searchConfig returns the text search config for a language tag
+func searchConfig(tag string) string {
+	base, _, _ := strings.Cut(tag, "-")
+	if config, ok := TitleSearchConfigs[base]; ok {
+		return config
+	}
+	return "simple"
+}
+
+// searchConfigs lists every config a title can be indexed with, sorted so the generated sql is stable
+func searchConfigs() []string {
+	configs := slices.Collect(maps.Values(TitleSearchConfigs))
+	configs = append(configs, "simple")
+	slices.Sort(configs)
+	return slices.Compact(configs)
+}
+
+func ValidateLocalizedTitle(v *validator.Validator, title *LocalizedTitle) {
+	v.Check(title.Title != "", "title", "must be provided")
+	v.Check(len(title.Title) < 500, "title", "must be smaller than 500 bytes")
+
+	_, ok := ParseLanguage(title.Language)
+	v.Check(ok, "language", "must be a valid language tag, e.g. es or pt-BR")
+}
+
+// Put sets the title of a movie in a language, replacing the one it had. created reports whether it's new
+func (m TitleModel) Put(ctx context.Context, title *LocalizedTitle) (created bool, err error) {
+	query := `
+		INSERT INTO movie_titles (movie_id, language, title, search_config)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (movie_id, language) DO UPDATE SET title = EXCLUDED.title
+		RETURNING id, xmax = 0`
+
+	args := []any{title.MovieID, title.Language, title.Title, searchConfig(title.Language)}
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Write)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&title.ID, &created)
+	if err != nil {
+		switch {
+		case strings.HasPrefix(err.Error(), `pq: insert or update on table "movie_titles" violates foreign key constraint`):
+			return false, ErrRecordNotFound
+		default:
+			return false, err
+		}
+	}
+	return created, nil
+}
+
+func (m TitleModel) Delete(ctx context.Context, movieID int, language string) error {
+	query := `
+		DELETE FROM movie_titles
+		WHERE movie_id = $1 AND language = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Write)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, movieID, language)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m TitleModel) GetAllForMovie(ctx context.Context, movieID int) ([]*LocalizedTitle, error) {
+	query := `
+		SELECT id, movie_id, language, title
+		FROM movie_titles
+		WHERE movie_id = $1
+		ORDER BY language`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Read)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	titles := []*LocalizedTitle{}
+	for rows.Next() {
+		var title LocalizedTitle
+		err := rows.Scan(&title.ID, &title.MovieID, &title.Language, &title.Title)
+		if err != nil {
+			return nil, err
+		}
+		titles = append(titles, &title)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return titles, nil
+}
+
+// Localize swaps the title of each movie for the best one in languages (see PreferredLanguages),
+// keeping the original in OriginalTitle. An exact tag beats a title of the same base language,
+// so for ["es-MX", "es"] an "es-MX" title wins over "es", which wins over "es-ES"
+func (m TitleModel) Localize(ctx context.Context, movies []*Movie, languages []string) error {
+	if len(movies) == 0 || len(languages) == 0 {
+		return nil
+	}
+
+	query := `
+		SELECT DISTINCT ON (movie_id) movie_id, language, title
+		FROM movie_titles
+		WHERE movie_id = ANY($1)
+		AND   (language = ANY($2) OR split_part(language, '-', 1) = ANY($2))
+		ORDER BY movie_id,
+		         least(array_position($2, language) * 2, array_position($2, split_part(language, '-', 1)) * 2 + 1),
+		         language`
+
+	ids := make([]int, 0, len(movies))
+	byID := make(map[int]*Movie, len(movies))
+	for _, movie := range movies {
+		ids = append(ids, movie.ID)
+		byID[movie.ID] = movie
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Read)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids), pq.Array(languages))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var lang, title string
+		err := rows.Scan(&id, &lang, &title)
+		if err != nil {
+			return err
+		}
+
+		movie := byID[id]
+		movie.OriginalTitle = movie.Title
+		movie.Title = title
+		movie.TitleLanguage = lang
+	}
+
+	return rows.Err()
+}
+
+// localizedTitleMatch is the condition for a full text search over the localized titles. The query has
+// to be parsed with the config of each title, one branch per config keeps movie_titles_search_vector_idx usable
+func localizedTitleMatch(title string) string {
+	branches := []string{}
+	for _, config := range searchConfigs() {
+		branches = append(branches, fmt.Sprintf(
+			`(search_config = '%[1]s' AND search_vector @@ plainto_tsquery('%[1]s', %[2]s))`, config, title))
+	}
+	return strings.Join(branches, " OR ")
+}
@@ -0,0 +1,115 @@
+package data
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"golang.org/x/text/language"
+)
+
+func TestParseLanguage(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string // empty when the tag must be rejected
+	}{
+		{"es", "es"},
+		{"pt-br", "pt-BR"},
+		{"PT-BR", "pt-BR"},
+		{"en-gb", "en-GB"},
+		{"es-419", "es-419"},
+		{"zh-hant-tw", "zh-Hant-TW"},
+		{"und", ""},
+		{"und-BR", ""},
+		{"", ""},
+		{"english", ""},
+		{"pt_br!", ""},
+	}
+
+	for _, tt := range tests {
+		got, ok := ParseLanguage(tt.input)
+		switch {
+		case tt.want == "" && ok:
+			t.Errorf("ParseLanguage(%q) = %q, want it rejected", tt.input, got)
+		case tt.want != "" && (!ok || got != tt.want):
+			t.Errorf("ParseLanguage(%q) = %q, %t, want %q", tt.input, got, ok, tt.want)
+		}
+	}
+}
+
+func TestPreferredLanguages(t *testing.T) {
+	tests := []struct {
+		header string // an Accept-Language header
+		want   []string
+	}{
+		{"", []string{}},
+		{"es", []string{"es"}},
+		{"pt-br", []string{"pt-BR", "pt"}},
+		{"es-MX, es", []string{"es-MX", "es"}},
+		{"es, es-MX", []string{"es", "es-MX"}},
+		{"pt-BR, pt-PT", []string{"pt-BR", "pt", "pt-PT"}},
+		{"fr;q=0.5, de", []string{"de", "fr"}},
+		// movies.title is in the default language, nothing after it can win
+		{"en", []string{}},
+		{"en, es", []string{}},
+		{"fr, en, de", []string{"fr"}},
+		// a regional variant of the default language is still better than movies.title
+		{"en-GB, en", []string{"en-GB"}},
+		{"en-GB, es", []string{"en-GB"}},
+		// und says nothing about the language
+		{"und", []string{}},
+		{"*, es", []string{"es"}},
+	}
+
+	for _, tt := range tests {
+		tags, _, err := language.ParseAcceptLanguage(tt.header)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.header, err)
+		}
+
+		got := PreferredLanguages(tags)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%q: got %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+// TestPostgresLocalize checks the order Localize picks titles in, it runs against the database in
+// GREENLIGHT_TEST_DB_DSN like TestPostgresStores
+func TestPostgresLocalize(t *testing.T) {
+	models := postgresModels(t)(t)
+
+	movie := insertMovie(t, models, "The Movie", 2000)
+	for lang, title := range map[string]string{"es": "La película", "es-MX": "La película MX", "es-ES": "La película ES"} {
+		_, err := models.Titles.Put(context.Background(), &LocalizedTitle{MovieID: movie.ID, Language: lang, Title: title})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		languages []string
+		want      string
+	}{
+		// an exact tag beats a title of the same base language
+		{[]string{"es-MX", "es"}, "es-MX"},
+		{[]string{"es-ES", "es"}, "es-ES"},
+		{[]string{"es"}, "es"},
+		// a regional title only stands in for its base language when there's no title in it
+		{[]string{"es-AR", "es"}, "es"},
+		// no title in the language, the original stays
+		{[]string{"fr"}, ""},
+	}
+
+	for _, tt := range tests {
+		movies := []*Movie{{ID: movie.ID, Title: movie.Title}}
+		err := models.Titles.Localize(context.Background(), movies, tt.languages)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if movies[0].TitleLanguage != tt.want {
+			t.Errorf("%q: got the %q title, want %q", tt.languages, movies[0].TitleLanguage, tt.want)
+		}
+	}
+}
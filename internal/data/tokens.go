@@ -4,7 +4,6 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
-	"database/sql"
 	"greenlight/internal/validator"
 	"time"
 )
@@ -23,7 +22,7 @@ func ValidateTokenPlainText(v *validator.Validator, tokenPlainText string) {
 }
 
 type TokenModel struct {
-	DB *sql.DB
+	DB *DB
 }
 
 type Token struct {
@@ -37,23 +36,23 @@ type Token struct {
 }
 
 // New generates a token and inserts into tokens database table
-func (m *TokenModel) New(userID int, ttl time.Duration, scope string) (*Token, error) {
+func (m *TokenModel) New(ctx context.Context, userID int, ttl time.Duration, scope string) (*Token, error) {
 
 	token := generateToken(userID, ttl, scope)
 
-	err := m.Insert(token)
+	err := m.Insert(ctx, token)
 	return token, err
 
 }
 
-func (m *TokenModel) Insert(token *Token) error {
+func (m *TokenModel) Insert(ctx context.Context, token *Token) error {
 
 	query := `INSERT INTO tokens (hash , user_id, expiry, scope) 
 			  VALUES ($1, $2, $3, $4)`
 
 	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Write)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, args...)
@@ -62,10 +61,10 @@ func (m *TokenModel) Insert(token *Token) error {
 }
 
 // DeleteAllForUser deletes all tokens of a specific scope for a use
-func (m *TokenModel) DeleteAllForUser(scope string, userID int) error {
+func (m *TokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int) error {
 	query := `DELETE FROM tokens
 	          WHERE user_id = $2 AND scope = $1`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Write)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
@@ -0,0 +1,141 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// GetTrash lists the movies that were deleted but not purged yet
+func (m MovieModel) GetTrash(ctx context.Context, filters Filters) ([]*Movie, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, rating, votes, images, external_ids, version, deleted_at
+		FROM movies
+		WHERE deleted_at IS NOT NULL
+		ORDER BY %s
+		LIMIT $1 OFFSET $2`, filters.orderBy())
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Search)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*Movie{}
+	for rows.Next() {
+		var movie Movie
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Rating,
+			&movie.Votes,
+			&movie.Images,
+			&movie.ExternalIDs,
+			&movie.Version,
+			&movie.DeletedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		movies = append(movies, &movie)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return movies, metadata, nil
+}
+
+// Restore takes a movie out of the trash, restoring counts as a change so the version is bumped
+// and a revision is kept for it
+func (m MovieModel) Restore(ctx context.Context, id int, userID int) (*Movie, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		WITH restored AS (
+			UPDATE movies
+			SET deleted_at = NULL, version = version + 1
+			WHERE id = $1 AND deleted_at IS NOT NULL
+			RETURNING id, created_at, title, year, runtime, genres, rating, votes, images, external_ids, version
+		), ` + revisionCTE("restored", "$2") + `
+		SELECT id, created_at, title, year, runtime, genres, rating, votes, images, external_ids, version FROM restored`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Write)
+	defer cancel()
+
+	var movie Movie
+	err := m.DB.QueryRowContext(ctx, query, id, nullID(userID)).Scan(
+		&movie.ID,
+		&movie.CreatedAt,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		pq.Array(&movie.Genres),
+		&movie.Rating,
+		&movie.Votes,
+		&movie.Images,
+		&movie.ExternalIDs,
+		&movie.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &movie, nil
+}
+
+// Purge permanently deletes the movies that have been in the trash for longer than retention. It returns
+// how many there were and the storage keys of their images, which nothing uses anymore
+func (m MovieModel) Purge(ctx context.Context, retention time.Duration) (int64, []string, error) {
+	query := `
+		DELETE FROM movies
+		WHERE deleted_at IS NOT NULL AND deleted_at < $1
+		RETURNING images`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Bulk)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, time.Now().Add(-retention))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	var purged int64
+	var images []*MovieImages
+	for rows.Next() {
+		var movieImages *MovieImages
+		err := rows.Scan(&movieImages)
+		if err != nil {
+			return 0, nil, err
+		}
+		purged++
+		images = append(images, movieImages)
+	}
+	if err = rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	return purged, unusedKeys(images, nil), nil
+}
@@ -34,14 +34,14 @@ var (
 )
 
 type UserModel struct {
-	DB *sql.DB
+	DB *DB
 }
 
 func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
 }
 
-func (m *UserModel) GetByEmail(email string) (*User, error) {
+func (m *UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
 
 	query := `SELECT id, created_at, name, email, password_hash, activated,version
 			  FROM users
@@ -49,7 +49,7 @@ func (m *UserModel) GetByEmail(email string) (*User, error) {
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Read)
 	defer cancel()
 
 	//err := m.DB.QueryRow(query, id).Scan(
@@ -75,7 +75,7 @@ func (m *UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-func (m *UserModel) Insert(user *User) error {
+func (m *UserModel) Insert(ctx context.Context, user *User) error {
 
 	query := `INSERT INTO users (name,email,password_hash, activated)
 		      VALUES ( $1, $2, $3, $4 )
@@ -83,7 +83,7 @@ func (m *UserModel) Insert(user *User) error {
 
 	args := []any{user.Name, user.Email, user.Password.hash, user.Ativated}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Write)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
@@ -99,7 +99,7 @@ func (m *UserModel) Insert(user *User) error {
 	return nil
 }
 
-func (m *UserModel) Update(user *User) error {
+func (m *UserModel) Update(ctx context.Context, user *User) error {
 
 	query := `UPDATE users 
 			  SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
@@ -115,7 +115,7 @@ func (m *UserModel) Update(user *User) error {
 		user.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Write)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
@@ -186,7 +186,7 @@ func ValidateUser(v *validator.Validator, user *User) {
 }
 
 // GetForToken finds user that has a specific token
-func (m *UserModel) GetForToken(scope string, tokenPlainText string) (*User, error) {
+func (m *UserModel) GetForToken(ctx context.Context, scope string, tokenPlainText string) (*User, error) {
 
 	tokenHash := sha256.Sum256([]byte(tokenPlainText))
 
@@ -202,7 +202,7 @@ func (m *UserModel) GetForToken(scope string, tokenPlainText string) (*User, err
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Read)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
@@ -0,0 +1,204 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"greenlight/internal/validator"
+)
+
+// WatchlistEntry is a movie a user wants to watch
+type WatchlistEntry struct {
+	MovieID int       `json:"movie_id"`
+	Title   string    `json:"title"`
+	Year    int       `json:"year"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+// WatchedEntry is one viewing of a movie, WatchedOn is a date in YYYY-MM-DD form
+type WatchedEntry struct {
+	ID        int    `json:"id"`
+	MovieID   int    `json:"movie_id"`
+	Title     string `json:"title,omitempty"`
+	Year      int    `json:"year,omitzero"`
+	WatchedOn string `json:"watched_on"`
+}
+
+type WatchlistModel struct {
+	DB *DB
+}
+
+func ValidateWatchedEntry(v *validator.Validator, entry *WatchedEntry) {
+	v.Check(entry.MovieID > 0, "movie_id", "must be provided")
+
+	watchedOn, err := time.Parse(time.DateOnly, entry.WatchedOn)
+	v.Check(err == nil, "watched_on", "must be a date in YYYY-MM-DD format")
+	if err == nil {
+		v.Check(watchedOn.Year() >= 1888, "watched_on", "must be after 1888")
+		// a day of slack so users ahead of UTC can log what they watched today
+		v.Check(watchedOn.Before(time.Now().AddDate(0, 0, 1)), "watched_on", "must not be in the future")
+	}
+}
+
+// Add puts a movie on the user's watchlist, adding one that is already there does nothing
+func (m WatchlistModel) Add(ctx context.Context, userID int, movieID int) error {
+	query := `
+		INSERT INTO watchlist (user_id, movie_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Write)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, movieID)
+	return err
+}
+
+func (m WatchlistModel) Remove(ctx context.Context, userID int, movieID int) error {
+	query := `
+		DELETE FROM watchlist
+		WHERE user_id = $1 AND movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Write)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetAll lists the user's watchlist, movies in the trash are left out
+func (m WatchlistModel) GetAll(ctx context.Context, userID int, filters Filters) ([]*WatchlistEntry, Metadata, error) {
+	// wrapped so the movie id is the id tiebreak of the sort
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, title, year, added_at
+		FROM (
+			SELECT movies.id, movies.title, movies.year, watchlist.added_at
+			FROM watchlist
+			INNER JOIN movies ON movies.id = watchlist.movie_id
+			WHERE watchlist.user_id = $1 AND movies.deleted_at IS NULL
+		) AS watchlist
+		ORDER BY %s
+		LIMIT $2 OFFSET $3`, filters.orderBy())
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Search)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*WatchlistEntry{}
+	for rows.Next() {
+		var entry WatchlistEntry
+		err := rows.Scan(&totalRecords, &entry.MovieID, &entry.Title, &entry.Year, &entry.AddedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		entries = append(entries, &entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return entries, metadata, nil
+}
+
+// AddWatched logs a viewing of a movie
+func (m WatchlistModel) AddWatched(ctx context.Context, userID int, entry *WatchedEntry) error {
+	query := `
+		INSERT INTO watched (user_id, movie_id, watched_on)
+		VALUES ($1, $2, $3::date)
+		RETURNING id`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Write)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, userID, entry.MovieID, entry.WatchedOn).Scan(&entry.ID)
+}
+
+func (m WatchlistModel) DeleteWatched(ctx context.Context, userID int, id int) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM watched
+		WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Write)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetWatched lists the user's watched log, movies in the trash are left out
+func (m WatchlistModel) GetWatched(ctx context.Context, userID int, filters Filters) ([]*WatchedEntry, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, movie_id, title, year, to_char(watched_on, 'YYYY-MM-DD')
+		FROM (
+			SELECT watched.id, watched.movie_id, movies.title, movies.year, watched.watched_on
+			FROM watched
+			INNER JOIN movies ON movies.id = watched.movie_id
+			WHERE watched.user_id = $1 AND movies.deleted_at IS NULL
+		) AS watched
+		ORDER BY %s
+		LIMIT $2 OFFSET $3`, filters.orderBy())
+
+	ctx, cancel := context.WithTimeout(ctx, m.DB.Timeouts.Search)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*WatchedEntry{}
+	for rows.Next() {
+		var entry WatchedEntry
+		err := rows.Scan(&totalRecords, &entry.ID, &entry.MovieID, &entry.Title, &entry.Year, &entry.WatchedOn)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		entries = append(entries, &entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return entries, metadata, nil
+}
@@ -0,0 +1,70 @@
+package mailer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// File writes every email to a .eml file in a directory instead of sending it, any mail client can open them
+type File struct {
+	dir    string
+	sender string
+}
+
+// NewFile creates the directory if needed
+func NewFile(dir string, sender string) (*File, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &File{dir: dir, sender: sender}, nil
+}
+
+// Send names the file after the time and the message id, so listing the directory lists the emails in order.
+// It's written to a temporary file first and renamed, a file is never seen half written
+func (f *File) Send(messageID string, recipient string, templateFile string, data any) error {
+	message, err := newMessage(messageID, f.sender, recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	msg, err := message.msg()
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(f.dir, ".email-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	_, err = msg.WriteTo(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+
+	name := time.Now().UTC().Format("20060102T150405.000000000") + "-" + fileSafe(msg.GetMessageID()) + ".eml"
+	return os.Rename(file.Name(), filepath.Join(f.dir, name))
+}
+
+// fileSafe keeps the letters, digits and dots of a message id, the rest become _
+func fileSafe(messageID string) string {
+	messageID = strings.Trim(messageID, "<>")
+	return strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.') {
+			return r
+		}
+		return '_'
+	}, messageID)
+}
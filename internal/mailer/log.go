@@ -0,0 +1,32 @@
+package mailer
+
+import (
+	"log/slog"
+)
+
+// Log writes every email to the logger instead of sending it. The plain text body is logged as is, tokens
+// included, so it's only meant for development
+type Log struct {
+	logger *slog.Logger
+	sender string
+}
+
+func NewLog(logger *slog.Logger, sender string) *Log {
+	return &Log{logger: logger, sender: sender}
+}
+
+func (l *Log) Send(messageID string, recipient string, templateFile string, data any) error {
+	message, err := newMessage(messageID, l.sender, recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	l.logger.Info("email",
+		"message_id", message.ID,
+		"from", message.From,
+		"to", message.To,
+		"subject", message.Subject,
+		"body", message.PlainBody,
+	)
+	return nil
+}
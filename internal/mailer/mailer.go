@@ -4,7 +4,6 @@ package mailer
 import (
 	"bytes"
 	"embed"
-	"time"
 
 	"github.com/wneessen/go-mail"
 
@@ -18,91 +17,89 @@ import (
 //go:embed "templates"
 var templateFS embed.FS
 
-type Mailer struct {
-	client *mail.Client
-	sender string
+// Sender is what emails go out through, SMTP delivers them and the other backends (File, Log, Memory)
+// keep them around for development and tests. Send makes a single attempt, retrying is up to the caller.
+// messageID is the Message-ID header, passing the same one on every attempt lets mail servers drop the
+// copies of an email they already got
+type Sender interface {
+	Send(messageID string, recipient string, templateFile string, data any) error
 }
 
-func New(host string, port int, username, passsord, sender string) (*Mailer, error) {
-
-	client, err := mail.NewClient(
-		host,
-		mail.WithSMTPAuth(mail.SMTPAuthLogin),
-		mail.WithPort(port),
-		mail.WithUsername(username),
-		mail.WithPassword(passsord),
-		mail.WithTimeout(5*time.Second),
-	)
-
-	if err != nil {
-		return nil, err
-	}
-
-	mailer := &Mailer{
-		client: client,
-		sender: sender,
-	}
-
-	return mailer, nil
-
+// Message is an email rendered from one of the templates
+type Message struct {
+	ID        string
+	From      string
+	To        string
+	Template  string
+	Subject   string
+	PlainBody string
+	HTMLBody  string
 }
 
-func (m *Mailer) Send(recipient string, templateFile string, data any) error {
+// newMessage renders templateFile with data
+func newMessage(messageID, sender, recipient, templateFile string, data any) (*Message, error) {
 
 	textTmpl, err := tt.New("").ParseFS(templateFS, "templates/"+templateFile)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	subject := new(bytes.Buffer)
 	err = textTmpl.ExecuteTemplate(subject, "subject", data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	plainBody := new(bytes.Buffer)
 	err = textTmpl.ExecuteTemplate(plainBody, "plainBody", data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	htmlBody := new(bytes.Buffer)
 	err = textTmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	message := &Message{
+		ID:        messageID,
+		From:      sender,
+		To:        recipient,
+		Template:  templateFile,
+		Subject:   subject.String(),
+		PlainBody: plainBody.String(),
+		HTMLBody:  htmlBody.String(),
 	}
 
+	return message, nil
+}
+
+// msg builds the go-mail message, without a message id one is generated
+func (m *Message) msg() (*mail.Msg, error) {
 	//  initialize a new mail.Msg instance
 	msg := mail.NewMsg()
 
-	err = msg.To(recipient)
+	err := msg.To(m.To)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = msg.From(m.sender)
+	err = msg.From(m.From)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	msg.Subject(subject.String())
-	msg.SetBodyString(mail.TypeTextPlain, plainBody.String())
-	msg.AddAlternativeString(mail.TypeTextHTML, htmlBody.String())
-
-	// opens a connection SMTP server, sends message, closes connection
-	for i := 1; i <= 3; i++ {
-		err = m.client.DialAndSend(msg)
-		if err == nil {
-			return nil
-		}
-
-		if i != 3 {
-			time.Sleep(500 * time.Millisecond)
-		}
-
+	if m.ID != "" {
+		msg.SetMessageIDWithValue(m.ID)
+	} else {
+		msg.SetMessageID()
 	}
+	msg.SetDate()
+	msg.Subject(m.Subject)
+	msg.SetBodyString(mail.TypeTextPlain, m.PlainBody)
+	msg.AddAlternativeString(mail.TypeTextHTML, m.HTMLBody)
 
-	return err
-
+	return msg, nil
 }
@@ -0,0 +1,81 @@
+package mailer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var welcomeData = map[string]any{"activationToken": "AAAAAAAAAAAAAAAAAAAAAAAAAA", "userID": 7}
+
+func TestFile(t *testing.T) {
+	dir := t.TempDir()
+
+	f, err := NewFile(dir, "Greenlight <no-reply@example.com>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = f.Send("outbox.1.1700000000@greenlight", "alice@example.com", "user_welcome.tmpl", welcomeData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || !strings.HasSuffix(names[0], "-outbox.1.1700000000_greenlight.eml") {
+		t.Fatalf("got %v, want a single .eml file named after the message id", names)
+	}
+
+	eml, err := os.ReadFile(names[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Message-ID: <outbox.1.1700000000@greenlight>",
+		"To: <alice@example.com>",
+		"Subject: Welcome to Greenlight!",
+		"AAAAAAAAAAAAAAAAAAAAAAAAAA",
+	} {
+		if !strings.Contains(string(eml), want) {
+			t.Errorf("the .eml file doesn't contain %q", want)
+		}
+	}
+}
+
+func TestMemory(t *testing.T) {
+	m := NewMemory("Greenlight <no-reply@example.com>")
+
+	err := m.Send("1@greenlight", "alice@example.com", "user_welcome.tmpl", welcomeData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errDown := errors.New("smtp: connection refused")
+	m.Fail(errDown)
+	err = m.Send("2@greenlight", "bob@example.com", "user_welcome.tmpl", welcomeData)
+	if !errors.Is(err, errDown) {
+		t.Errorf("got %v, want the error given to Fail", err)
+	}
+
+	err = m.Send("3@greenlight", "alice@example.com", "missing.tmpl", nil)
+	if err == nil {
+		t.Error("got no error for a template that doesn't exist")
+	}
+
+	messages := m.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want only the one sent before failing", len(messages))
+	}
+	got := messages[0]
+	if got.ID != "1@greenlight" || got.To != "alice@example.com" || got.Subject != "Welcome to Greenlight!" {
+		t.Errorf("got %+v", got)
+	}
+	if !strings.Contains(got.PlainBody, "your user ID number is 7") {
+		t.Errorf("got body %q, want it rendered with the data", got.PlainBody)
+	}
+}
@@ -0,0 +1,52 @@
+package mailer
+
+import (
+	"slices"
+	"sync"
+)
+
+// Memory keeps the emails it's given instead of sending them, for tests to check what would have gone out
+type Memory struct {
+	mu       sync.Mutex
+	sender   string
+	messages []Message
+	err      error
+}
+
+func NewMemory(sender string) *Memory {
+	return &Memory{sender: sender}
+}
+
+// Send renders the email like the other backends do, so a broken template fails here too
+func (m *Memory) Send(messageID string, recipient string, templateFile string, data any) error {
+	message, err := newMessage(messageID, m.sender, recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.err != nil {
+		return m.err
+	}
+
+	m.messages = append(m.messages, *message)
+	return nil
+}
+
+// Messages returns the emails sent so far, oldest first
+func (m *Memory) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return slices.Clone(m.messages)
+}
+
+// Fail makes every Send return err from now on, until it's called again with nil
+func (m *Memory) Fail(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.err = err
+}
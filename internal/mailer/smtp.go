@@ -0,0 +1,51 @@
+package mailer
+
+import (
+	"time"
+
+	"github.com/wneessen/go-mail"
+)
+
+// SMTP delivers emails through an SMTP server
+type SMTP struct {
+	client *mail.Client
+	sender string
+}
+
+// NewSMTP doesn't connect yet, every Send dials the server. Without a username no authentication is done
+func NewSMTP(host string, port int, username, password, sender string) (*SMTP, error) {
+
+	options := []mail.Option{
+		mail.WithPort(port),
+		mail.WithTimeout(5 * time.Second),
+	}
+	if username != "" {
+		options = append(options,
+			mail.WithSMTPAuth(mail.SMTPAuthLogin),
+			mail.WithUsername(username),
+			mail.WithPassword(password),
+		)
+	}
+
+	client, err := mail.NewClient(host, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SMTP{client: client, sender: sender}, nil
+}
+
+func (s *SMTP) Send(messageID string, recipient string, templateFile string, data any) error {
+	message, err := newMessage(messageID, s.sender, recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	msg, err := message.msg()
+	if err != nil {
+		return err
+	}
+
+	// opens a connection SMTP server, sends message, closes connection
+	return s.client.DialAndSend(msg)
+}
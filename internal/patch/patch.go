@@ -0,0 +1,364 @@
+// Package patch applies JSON Merge Patch (RFC 7386) and JSON Patch (RFC 6902) documents to raw JSON
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidPatch means the patch document itself is malformed
+	ErrInvalidPatch = errors.New("invalid patch document")
+	// ErrTestFailed means a JSON Patch test operation didn't match the document
+	ErrTestFailed = errors.New("test operation failed")
+)
+
+// OperationError is returned when a well formed operation can't be applied to the document,
+// e.g. it removes a path that doesn't exist
+type OperationError struct {
+	Index int
+	Op    string
+	Path  string
+	Err   string
+}
+
+func (e *OperationError) Error() string {
+	return fmt.Sprintf("operation %d (%s %q): %s", e.Index, e.Op, e.Path, e.Err)
+}
+
+// Merge applies a JSON Merge Patch: objects are merged recursively, null removes a key
+// and anything else replaces the target value
+func Merge(doc, patch []byte) ([]byte, error) {
+	var target, p any
+
+	err := json.Unmarshal(doc, &target)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(patch, &p)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPatch, err)
+	}
+
+	return json.Marshal(mergeValue(target, p))
+}
+
+func mergeValue(target, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = make(map[string]any)
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+		targetObject[key] = mergeValue(targetObject[key], value)
+	}
+
+	return targetObject
+}
+
+// Operation is a single JSON Patch operation, Value is kept raw so a missing value can be told apart from null
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Apply runs a JSON Patch. Operations are applied in order and if any of them fails the whole patch does
+func Apply(doc, patch []byte) ([]byte, error) {
+	var target any
+
+	err := json.Unmarshal(doc, &target)
+	if err != nil {
+		return nil, err
+	}
+
+	// unknown members in an operation must be ignored (RFC 6902 section 4)
+	var operations []Operation
+
+	err = json.Unmarshal(patch, &operations)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPatch, err)
+	}
+
+	for i, op := range operations {
+		target, err = applyOperation(target, op)
+		if err != nil {
+			var opError *OperationError
+			if errors.As(err, &opError) {
+				opError.Index = i
+			}
+			return nil, err
+		}
+	}
+
+	return json.Marshal(target)
+}
+
+func applyOperation(doc any, op Operation) (any, error) {
+	fail := func(format string, args ...any) error {
+		return &OperationError{Op: op.Op, Path: op.Path, Err: fmt.Sprintf(format, args...)}
+	}
+
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPatch, err)
+	}
+
+	var value any
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, fmt.Errorf("%w: %s operation needs a value", ErrInvalidPatch, op.Op)
+		}
+		err = json.Unmarshal(op.Value, &value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidPatch, err)
+		}
+	}
+
+	var from []string
+	switch op.Op {
+	case "move", "copy":
+		from, err = parsePointer(op.From)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidPatch, err)
+		}
+	}
+
+	switch op.Op {
+	case "add":
+		return add(doc, path, value, fail)
+
+	case "remove":
+		doc, _, err = remove(doc, path, fail)
+		return doc, err
+
+	case "replace":
+		if len(path) == 0 {
+			return value, nil
+		}
+		doc, _, err = remove(doc, path, fail)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, path, value, fail)
+
+	case "move":
+		if len(from) < len(path) && reflect.DeepEqual(from, path[:len(from)]) {
+			return nil, fail("a value can't be moved into one of its children")
+		}
+		doc, value, err = remove(doc, from, fail)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, path, value, fail)
+
+	case "copy":
+		value, err = get(doc, from, fail)
+		if err != nil {
+			return nil, err
+		}
+		value, err = deepCopy(value)
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, path, value, fail)
+
+	case "test":
+		current, err := get(doc, path, fail)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(current, value) {
+			return nil, fmt.Errorf("%w: %q", ErrTestFailed, op.Path)
+		}
+		return doc, nil
+
+	default:
+		return nil, fmt.Errorf("%w: unknown operation %q", ErrInvalidPatch, op.Op)
+	}
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into its unescaped tokens, "" is the whole document
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("path %q must start with /", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		// ~1 has to be replaced first, otherwise "~01" would turn into "/" instead of "~1"
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+type failFunc func(format string, args ...any) error
+
+// arrayIndex parses an array index token, "-" (one past the end) is only allowed when appending
+func arrayIndex(token string, length int, appending bool, fail failFunc) (int, error) {
+	if token == "-" && appending {
+		return length, nil
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fail("invalid array index %q", token)
+	}
+
+	limit := length - 1
+	if appending {
+		limit = length
+	}
+	if i > limit {
+		return 0, fail("array index %d out of bounds", i)
+	}
+
+	return i, nil
+}
+
+func get(doc any, path []string, fail failFunc) (any, error) {
+	node := doc
+	for _, token := range path {
+		switch n := node.(type) {
+		case map[string]any:
+			child, ok := n[token]
+			if !ok {
+				return nil, fail("path does not exist")
+			}
+			node = child
+		case []any:
+			i, err := arrayIndex(token, len(n), false, fail)
+			if err != nil {
+				return nil, err
+			}
+			node = n[i]
+		default:
+			return nil, fail("path does not exist")
+		}
+	}
+	return node, nil
+}
+
+// update walks down to the parent of the last token and lets fn change it. Arrays can be reallocated
+// by fn so every level hands the (possibly new) child back to its own parent
+func update(node any, path []string, fail failFunc, fn func(parent any, token string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return fn(node, path[0])
+	}
+
+	switch n := node.(type) {
+	case map[string]any:
+		child, ok := n[path[0]]
+		if !ok {
+			return nil, fail("path does not exist")
+		}
+		child, err := update(child, path[1:], fail, fn)
+		if err != nil {
+			return nil, err
+		}
+		n[path[0]] = child
+		return n, nil
+
+	case []any:
+		i, err := arrayIndex(path[0], len(n), false, fail)
+		if err != nil {
+			return nil, err
+		}
+		child, err := update(n[i], path[1:], fail, fn)
+		if err != nil {
+			return nil, err
+		}
+		n[i] = child
+		return n, nil
+
+	default:
+		return nil, fail("path does not exist")
+	}
+}
+
+func add(doc any, path []string, value any, fail failFunc) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	return update(doc, path, fail, func(parent any, token string) (any, error) {
+		switch p := parent.(type) {
+		case map[string]any:
+			p[token] = value
+			return p, nil
+		case []any:
+			i, err := arrayIndex(token, len(p), true, fail)
+			if err != nil {
+				return nil, err
+			}
+			p = append(p, nil)
+			copy(p[i+1:], p[i:])
+			p[i] = value
+			return p, nil
+		default:
+			return nil, fail("path does not exist")
+		}
+	})
+}
+
+// remove deletes the value at path and returns it, so move can add it back somewhere else
+func remove(doc any, path []string, fail failFunc) (any, any, error) {
+	if len(path) == 0 {
+		return nil, nil, fail("the whole document can't be removed")
+	}
+
+	var removed any
+	doc, err := update(doc, path, fail, func(parent any, token string) (any, error) {
+		switch p := parent.(type) {
+		case map[string]any:
+			value, ok := p[token]
+			if !ok {
+				return nil, fail("path does not exist")
+			}
+			removed = value
+			delete(p, token)
+			return p, nil
+		case []any:
+			i, err := arrayIndex(token, len(p), false, fail)
+			if err != nil {
+				return nil, err
+			}
+			removed = p[i]
+			return append(p[:i], p[i+1:]...), nil
+		default:
+			return nil, fail("path does not exist")
+		}
+	})
+
+	return doc, removed, err
+}
+
+func deepCopy(value any) (any, error) {
+	js, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var clone any
+	err = json.Unmarshal(js, &clone)
+	return clone, err
+}
@@ -0,0 +1,163 @@
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// canonical re-encodes a JSON document so that documents that only differ in key order or spacing compare equal
+func canonical(t *testing.T, doc string) string {
+	t.Helper()
+
+	var v any
+	err := json.Unmarshal([]byte(doc), &v)
+	if err != nil {
+		t.Fatalf("invalid JSON %s: %v", doc, err)
+	}
+
+	js, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(js)
+}
+
+// the examples of RFC 7386 appendix A
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		doc   string
+		patch string
+		want  string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+		// deleting a key that isn't there is not an error
+		{`{"a":"b"}`, `{"x":null}`, `{"a":"b"}`},
+	}
+
+	for _, tt := range tests {
+		got, err := Merge([]byte(tt.doc), []byte(tt.patch))
+		if err != nil {
+			t.Errorf("%s merged with %s: %v", tt.doc, tt.patch, err)
+			continue
+		}
+		if string(got) != canonical(t, tt.want) {
+			t.Errorf("%s merged with %s: got %s, want %s", tt.doc, tt.patch, got, tt.want)
+		}
+	}
+
+	_, err := Merge([]byte(`{"a":"b"}`), []byte(`{"a":`))
+	if !errors.Is(err, ErrInvalidPatch) {
+		t.Errorf("got %v for a malformed patch, want ErrInvalidPatch", err)
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string // empty when the patch must fail
+		err   error  // for failing patches, nil means an *OperationError
+	}{
+		// RFC 6902 appendix A
+		{"A.1 add an object member", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`, nil},
+		{"A.2 add an array element", `{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`, nil},
+		{"A.3 remove an object member", `{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`, nil},
+		{"A.4 remove an array element", `{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`, nil},
+		{"A.5 replace a value", `{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`, nil},
+		{"A.6 move a value", `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			`[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`, nil},
+		{"A.7 move an array element", `{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`,
+			`{"foo":["all","cows","eat","grass"]}`, nil},
+		{"A.8 test a value", `{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`,
+			`{"baz":"qux","foo":["a",2,"c"]}`, nil},
+		{"A.9 failing test", `{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, "", ErrTestFailed},
+		{"A.10 add a nested member object", `{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`,
+			`{"foo":"bar","child":{"grandchild":{}}}`, nil},
+		{"A.11 ignore unrecognized elements", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`, `{"foo":"bar","baz":"qux"}`, nil},
+		{"A.12 add to a nonexistent target", `{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, "", nil},
+		{"A.14 ~ escape ordering", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10}]`, `{"/":9,"~1":10}`, nil},
+		{"A.15 comparing strings and numbers", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":"10"}]`, "", ErrTestFailed},
+		{"A.16 add an array value", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`, nil},
+
+		// pointer escaping
+		{"~1 is a slash", `{}`, `[{"op":"add","path":"/a~1b","value":1}]`, `{"a/b":1}`, nil},
+		{"~0 is a tilde", `{"m~n":8}`, `[{"op":"replace","path":"/m~0n","value":9}]`, `{"m~n":9}`, nil},
+		{"path without a leading slash", `{"a":1}`, `[{"op":"remove","path":"a"}]`, "", ErrInvalidPatch},
+
+		// array indices
+		{"- appends", `{"foo":[1,2]}`, `[{"op":"add","path":"/foo/-","value":3}]`, `{"foo":[1,2,3]}`, nil},
+		{"- only when adding", `{"foo":[1,2]}`, `[{"op":"remove","path":"/foo/-"}]`, "", nil},
+		{"add at the end", `{"foo":[1]}`, `[{"op":"add","path":"/foo/1","value":2}]`, `{"foo":[1,2]}`, nil},
+		{"add past the end", `{"foo":[1]}`, `[{"op":"add","path":"/foo/2","value":2}]`, "", nil},
+		{"remove past the end", `{"foo":[1]}`, `[{"op":"remove","path":"/foo/1"}]`, "", nil},
+		{"negative index", `{"foo":[1]}`, `[{"op":"replace","path":"/foo/-1","value":2}]`, "", nil},
+		{"leading zero", `{"foo":[1,2]}`, `[{"op":"remove","path":"/foo/01"}]`, "", nil},
+
+		// other operations
+		{"move into its own child", `{"a":{"b":1}}`, `[{"op":"move","from":"/a","path":"/a/b/c"}]`, "", nil},
+		{"move onto itself", `{"a":1}`, `[{"op":"move","from":"/a","path":"/a"}]`, `{"a":1}`, nil},
+		{"copy is deep", `{"a":{"b":1}}`, `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`,
+			`{"a":{"b":1},"c":{"b":2}}`, nil},
+		{"replace the whole document", `{"a":1}`, `[{"op":"replace","path":"","value":[1]}]`, `[1]`, nil},
+		{"replace a missing member", `{"a":1}`, `[{"op":"replace","path":"/b","value":2}]`, "", nil},
+		{"remove the whole document", `{"a":1}`, `[{"op":"remove","path":""}]`, "", nil},
+		{"null value", `{"a":1}`, `[{"op":"replace","path":"/a","value":null}]`, `{"a":null}`, nil},
+		{"missing value", `{"a":1}`, `[{"op":"replace","path":"/a"}]`, "", ErrInvalidPatch},
+		{"unknown operation", `{"a":1}`, `[{"op":"increment","path":"/a"}]`, "", ErrInvalidPatch},
+		{"not an array", `{"a":1}`, `{"op":"remove","path":"/a"}`, "", ErrInvalidPatch},
+
+		// a failing operation fails the whole patch, including the ones before it
+		{"all or nothing", `{"a":1}`, `[{"op":"remove","path":"/a"},{"op":"test","path":"/a","value":1}]`, "", nil},
+	}
+
+	for _, tt := range tests {
+		got, err := Apply([]byte(tt.doc), []byte(tt.patch))
+
+		if tt.want != "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			} else if string(got) != canonical(t, tt.want) {
+				t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+			}
+			continue
+		}
+
+		var opError *OperationError
+		switch {
+		case err == nil:
+			t.Errorf("%s: got %s, want an error", tt.name, got)
+		case tt.err != nil && !errors.Is(err, tt.err):
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		case tt.err == nil && !errors.As(err, &opError):
+			t.Errorf("%s: got %v, want an OperationError", tt.name, err)
+		}
+	}
+}
+
+func TestApplyOperationIndex(t *testing.T) {
+	_, err := Apply([]byte(`{"a":1}`), []byte(`[{"op":"test","path":"/a","value":1},{"op":"remove","path":"/b"}]`))
+
+	var opError *OperationError
+	if !errors.As(err, &opError) {
+		t.Fatalf("got %v, want an OperationError", err)
+	}
+	if opError.Index != 1 || opError.Op != "remove" || opError.Path != "/b" {
+		t.Errorf("got %+v, want the second operation", opError)
+	}
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Local stores files in a directory on disk, it also serves them so it can be mounted on a route
+type Local struct {
+	dir     string
+	baseURL string
+}
+
+// NewLocal creates the directory if needed, baseURL is where the app serves the files from (e.g. /images)
+func NewLocal(dir string, baseURL string) (*Local, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Local{dir: dir, baseURL: strings.TrimSuffix(baseURL, "/")}, nil
+}
+
+// Put writes to a temporary file first and renames it, so a file is never served half written
+func (l *Local) Put(ctx context.Context, key string, body io.Reader, contentType string) (string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	name := filepath.Join(l.dir, filepath.FromSlash(key))
+
+	err = os.MkdirAll(filepath.Dir(name), 0o755)
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+
+	_, err = io.Copy(file, body)
+	if err != nil {
+		file.Close()
+		return "", err
+	}
+
+	err = file.Close()
+	if err != nil {
+		return "", err
+	}
+
+	err = ctx.Err()
+	if err != nil {
+		return "", err
+	}
+
+	err = os.Chmod(file.Name(), 0o644)
+	if err != nil {
+		return "", err
+	}
+
+	err = os.Rename(file.Name(), name)
+	if err != nil {
+		return "", err
+	}
+
+	return l.baseURL + "/" + key, nil
+}
+
+// Delete removes a file, deleting one that isn't there is not an error
+func (l *Local) Delete(ctx context.Context, key string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(filepath.Join(l.dir, filepath.FromSlash(key)))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// ServeHTTP serves the stored files, the request path is the key. Directories are never listed
+func (l *Local) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key, err := cleanKey(strings.TrimPrefix(r.URL.Path, "/"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	name := filepath.Join(l.dir, filepath.FromSlash(key))
+
+	info, err := os.Stat(name)
+	if err != nil || info.IsDir() || strings.HasPrefix(filepath.Base(name), ".") {
+		http.NotFound(w, r)
+		return
+	}
+
+	// names change on every upload, so they can be cached for good
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeFile(w, r, name)
+}
@@ -0,0 +1,28 @@
+// Package storage keeps uploaded files (like movie posters) somewhere they can be served from
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path"
+	"strings"
+)
+
+var ErrInvalidKey = errors.New("invalid storage key")
+
+// Storage is where uploaded files end up. Keys are slash separated paths like "movies/1/poster.jpg",
+// Put returns the URL clients can fetch the file from
+type Storage interface {
+	Put(ctx context.Context, key string, body io.Reader, contentType string) (string, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// cleanKey rejects keys that could escape the storage root
+func cleanKey(key string) (string, error) {
+	cleaned := path.Clean("/" + key)[1:]
+	if key == "" || cleaned != key || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return "", ErrInvalidKey
+	}
+	return cleaned, nil
+}
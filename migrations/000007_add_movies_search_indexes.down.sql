@@ -0,0 +1,5 @@
+
+DROP INDEX IF EXISTS movies_title_trgm_idx;
+
+DROP INDEX IF EXISTS movies_title_idx;
+CREATE INDEX IF NOT EXISTS movies_title_idx ON movies USING GIN (to_tsvector('simple',title));
@@ -0,0 +1,8 @@
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- the old index used the 'simple' config while queries use 'english', so it was never picked
+DROP INDEX IF EXISTS movies_title_idx;
+CREATE INDEX IF NOT EXISTS movies_title_idx ON movies USING GIN (to_tsvector('english',title));
+
+CREATE INDEX IF NOT EXISTS movies_title_trgm_idx ON movies USING GIN (title gin_trgm_ops);
@@ -0,0 +1,3 @@
+
+-- used by the autocomplete endpoint for "title starts with" lookups
+CREATE INDEX IF NOT EXISTS movies_title_prefix_idx ON movies (lower(title) text_pattern_ops);
@@ -0,0 +1,21 @@
+
+CREATE TABLE IF NOT EXISTS movie_revisions (
+    -- listings sort with an id tiebreak
+    id bigint PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    version integer NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint REFERENCES users ON DELETE SET NULL,
+    title text NOT NULL,
+    year integer NOT NULL,
+    runtime integer NOT NULL,
+    genres text[] NOT NULL,
+    UNIQUE (movie_id, version)
+);
+
+-- the history starts with the current state of every movie
+INSERT INTO movie_revisions (movie_id, version, created_at, title, year, runtime, genres)
+SELECT id, version, created_at, title, year, runtime, genres
+FROM movies
+ORDER BY id
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,3 @@
+
+DROP TABLE IF EXISTS movie_credits;
+DROP TABLE IF EXISTS people;
@@ -0,0 +1,24 @@
+
+CREATE TABLE IF NOT EXISTS people (
+    id bigint PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    birth_year integer NOT NULL DEFAULT 0,
+    biography text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS people_name_trgm_idx ON people USING GIN (name gin_trgm_ops);
+
+CREATE TABLE IF NOT EXISTS movie_credits (
+    id bigint PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    person_id bigint NOT NULL REFERENCES people ON DELETE CASCADE,
+    role text NOT NULL CHECK (role IN ('director', 'actor', 'writer')),
+    character text NOT NULL DEFAULT '',
+    billing_order integer NOT NULL DEFAULT 0 CHECK (billing_order >= 0),
+    UNIQUE (movie_id, person_id, role)
+);
+
+-- the unique constraint already covers lookups by movie, filmographies and the person filter go by person
+CREATE INDEX IF NOT EXISTS movie_credits_person_id_idx ON movie_credits (person_id);
@@ -0,0 +1,7 @@
+
+DROP TABLE IF EXISTS reviews;
+DROP FUNCTION IF EXISTS reviews_update_movie_rating();
+DROP INDEX IF EXISTS movies_rating_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS rating;
+ALTER TABLE movies DROP COLUMN IF EXISTS votes;
+ALTER TABLE movies DROP COLUMN IF EXISTS rating_sum;
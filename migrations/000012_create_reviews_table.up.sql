@@ -0,0 +1,42 @@
+
+-- the aggregates live on the movie so listing and sorting by rating never touches the reviews
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS rating_sum bigint NOT NULL DEFAULT 0;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS votes integer NOT NULL DEFAULT 0;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS rating numeric(4, 2) GENERATED ALWAYS AS (
+    CASE WHEN votes = 0 THEN 0 ELSE round(rating_sum::numeric / votes, 2) END
+) STORED;
+
+CREATE INDEX IF NOT EXISTS movies_rating_idx ON movies (rating) WHERE deleted_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS reviews (
+    id bigint PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    rating smallint NOT NULL CHECK (rating BETWEEN 1 AND 10),
+    body text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1,
+    UNIQUE (movie_id, user_id)
+);
+
+-- keeps rating_sum and votes in step with the reviews, including the ones removed by cascading deletes
+CREATE OR REPLACE FUNCTION reviews_update_movie_rating() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND NEW.movie_id = OLD.movie_id THEN
+        UPDATE movies SET rating_sum = rating_sum - OLD.rating + NEW.rating WHERE id = NEW.movie_id;
+        RETURN NULL;
+    END IF;
+
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        UPDATE movies SET rating_sum = rating_sum - OLD.rating, votes = votes - 1 WHERE id = OLD.movie_id;
+    END IF;
+    IF TG_OP IN ('UPDATE', 'INSERT') THEN
+        UPDATE movies SET rating_sum = rating_sum + NEW.rating, votes = votes + 1 WHERE id = NEW.movie_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER reviews_update_movie_rating
+AFTER INSERT OR DELETE OR UPDATE OF rating, movie_id ON reviews
+FOR EACH ROW EXECUTE FUNCTION reviews_update_movie_rating();
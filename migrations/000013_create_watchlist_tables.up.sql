@@ -0,0 +1,18 @@
+
+CREATE TABLE IF NOT EXISTS watchlist (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    added_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, movie_id)
+);
+
+-- a movie can be watched more than once, so every viewing is its own entry
+CREATE TABLE IF NOT EXISTS watched (
+    id bigint PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    watched_on date NOT NULL DEFAULT CURRENT_DATE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS watched_user_id_watched_on_idx ON watched (user_id, watched_on);
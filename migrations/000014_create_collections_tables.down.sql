@@ -0,0 +1,4 @@
+
+DROP TABLE IF EXISTS collection_movies;
+DROP TABLE IF EXISTS collections;
+DELETE FROM permissions WHERE code = 'collections:admin';
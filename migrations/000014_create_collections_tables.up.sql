@@ -0,0 +1,26 @@
+
+CREATE TABLE IF NOT EXISTS collections (
+    id bigint PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    title text NOT NULL,
+    description text NOT NULL DEFAULT '',
+    visibility text NOT NULL DEFAULT 'private' CHECK (visibility IN ('private', 'unlisted', 'public')),
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS collections_user_id_idx ON collections (user_id);
+CREATE INDEX IF NOT EXISTS collections_public_idx ON collections (id) WHERE visibility = 'public';
+
+-- positions are shifted in bulk when reordering, so uniqueness is only checked at the end of each statement
+CREATE TABLE IF NOT EXISTS collection_movies (
+    collection_id bigint NOT NULL REFERENCES collections ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    position integer NOT NULL CHECK (position > 0),
+    PRIMARY KEY (collection_id, movie_id),
+    UNIQUE (collection_id, position) DEFERRABLE
+);
+
+INSERT INTO permissions (code)
+SELECT 'collections:admin'
+WHERE NOT EXISTS (SELECT 1 FROM permissions WHERE code = 'collections:admin');
@@ -0,0 +1,3 @@
+
+-- poster and thumbnails of a movie, NULL when it has none
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS images jsonb;
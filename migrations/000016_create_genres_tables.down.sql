@@ -0,0 +1,7 @@
+
+DROP TABLE IF EXISTS genre_aliases;
+DROP TABLE IF EXISTS genres;
+
+-- back to the index as 000003 created it
+DROP INDEX IF EXISTS movies_genres_idx;
+CREATE INDEX IF NOT EXISTS movies_genres_idx ON movies USING GIN (to_tsvector('simple',title));
@@ -0,0 +1,109 @@
+
+-- 000003 built movies_genres_idx over the title, genres @> filters never used it
+DROP INDEX IF EXISTS movies_genres_idx;
+CREATE INDEX IF NOT EXISTS movies_genres_idx ON movies USING GIN (genres);
+
+CREATE TABLE IF NOT EXISTS genres (
+    id bigint PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    slug text NOT NULL UNIQUE CHECK (slug ~ '^[a-z0-9]+(-[a-z0-9]+)*$'),
+    name text NOT NULL
+);
+
+-- every spelling a genre is accepted under, lowercase with single spaces. The slug and the
+-- lowercased name of each genre are aliases too, so lookups only ever go through this table
+CREATE TABLE IF NOT EXISTS genre_aliases (
+    alias text PRIMARY KEY,
+    genre_id bigint NOT NULL REFERENCES genres ON DELETE CASCADE
+);
+
+INSERT INTO genres (slug, name)
+VALUES
+('action', 'Action'),
+('adventure', 'Adventure'),
+('animation', 'Animation'),
+('biography', 'Biography'),
+('comedy', 'Comedy'),
+('crime', 'Crime'),
+('documentary', 'Documentary'),
+('drama', 'Drama'),
+('family', 'Family'),
+('fantasy', 'Fantasy'),
+('history', 'History'),
+('horror', 'Horror'),
+('music', 'Music'),
+('musical', 'Musical'),
+('mystery', 'Mystery'),
+('romance', 'Romance'),
+('science-fiction', 'Science Fiction'),
+('sport', 'Sport'),
+('thriller', 'Thriller'),
+('war', 'War'),
+('western', 'Western')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO genre_aliases (alias, genre_id)
+SELECT alias, genres.id
+FROM (VALUES
+    ('animated', 'animation'),
+    ('biopic', 'biography'),
+    ('comedies', 'comedy'),
+    ('docs', 'documentary'),
+    ('documentaries', 'documentary'),
+    ('romantic', 'romance'),
+    ('sci-fi', 'science-fiction'),
+    ('scifi', 'science-fiction'),
+    ('sf', 'science-fiction'),
+    ('sports', 'sport'),
+    ('historical', 'history')
+) AS aliases (alias, slug)
+INNER JOIN genres ON genres.slug = aliases.slug
+ON CONFLICT DO NOTHING;
+
+-- the slug and lowercased name of the seeded genres go in first, the movies' genres are checked against them
+INSERT INTO genre_aliases (alias, genre_id)
+SELECT slug, id FROM genres
+UNION
+SELECT lower(name), id FROM genres
+ON CONFLICT DO NOTHING;
+
+-- whatever the movies already use and isn't known yet becomes a genre of its own, so nothing is lost.
+-- A genre is known when either of its forms is an alias, "Sci Fi" is the sci-fi alias as a slug
+INSERT INTO genres (slug, name)
+SELECT DISTINCT ON (slug) slug, name
+FROM (
+    SELECT trim(both '-' from regexp_replace(lower(genre), '[^a-z0-9]+', '-', 'g')) AS slug,
+           lower(regexp_replace(trim(genre), '\s+', ' ', 'g')) AS spaced,
+           trim(genre) AS name
+    FROM movies, unnest(movies.genres) AS genre
+) AS existing
+WHERE slug <> ''
+AND NOT EXISTS (SELECT 1 FROM genre_aliases WHERE alias IN (existing.spaced, existing.slug))
+ORDER BY slug, name
+ON CONFLICT DO NOTHING;
+
+-- the new genres get the same aliases as the seeded ones
+INSERT INTO genre_aliases (alias, genre_id)
+SELECT slug, id FROM genres
+UNION
+SELECT lower(name), id FROM genres
+ON CONFLICT DO NOTHING;
+
+-- and the movies are rewritten to use the canonical slugs, in their original order without duplicates
+UPDATE movies
+SET genres = normalized.genres
+FROM (
+    SELECT movies.id, array_agg(resolved.slug ORDER BY resolved.position) AS genres
+    FROM movies
+    CROSS JOIN LATERAL (
+        SELECT DISTINCT ON (genres.slug) genres.slug, requested.position
+        FROM unnest(movies.genres) WITH ORDINALITY AS requested (genre, position)
+        INNER JOIN genre_aliases ON genre_aliases.alias IN (
+            lower(regexp_replace(trim(requested.genre), '\s+', ' ', 'g')),
+            trim(both '-' from regexp_replace(lower(requested.genre), '[^a-z0-9]+', '-', 'g'))
+        )
+        INNER JOIN genres ON genres.id = genre_aliases.genre_id
+        ORDER BY genres.slug, requested.position
+    ) AS resolved
+    GROUP BY movies.id
+) AS normalized
+WHERE movies.id = normalized.id AND movies.genres IS DISTINCT FROM normalized.genres;
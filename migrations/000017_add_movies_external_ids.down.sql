@@ -0,0 +1,11 @@
+
+DELETE FROM movie_revisions WHERE merged_from IS NOT NULL;
+DROP INDEX IF EXISTS movie_revisions_movie_id_version_idx;
+ALTER TABLE movie_revisions ADD CONSTRAINT movie_revisions_movie_id_version_key UNIQUE (movie_id, version);
+ALTER TABLE movie_revisions DROP COLUMN IF EXISTS merged_from;
+
+DROP INDEX IF EXISTS movies_year_idx;
+DROP INDEX IF EXISTS movies_tmdb_id_idx;
+DROP INDEX IF EXISTS movies_imdb_id_idx;
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_external_ids_check;
+ALTER TABLE movies DROP COLUMN IF EXISTS external_ids;
@@ -0,0 +1,15 @@
+
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS external_ids jsonb NOT NULL DEFAULT '{}';
+
+-- only known sources are accepted, each one gets its own unique index
+ALTER TABLE movies ADD CONSTRAINT movies_external_ids_check CHECK (external_ids - ARRAY['imdb', 'tmdb'] = '{}'::jsonb);
+CREATE UNIQUE INDEX IF NOT EXISTS movies_imdb_id_idx ON movies ((external_ids->>'imdb')) WHERE external_ids ? 'imdb';
+CREATE UNIQUE INDEX IF NOT EXISTS movies_tmdb_id_idx ON movies ((external_ids->>'tmdb')) WHERE external_ids ? 'tmdb';
+
+-- the duplicate finder joins movies of the same year
+CREATE INDEX IF NOT EXISTS movies_year_idx ON movies (year) WHERE deleted_at IS NULL;
+
+-- revisions of a movie merged into another one move with it, keeping their own version numbers
+ALTER TABLE movie_revisions ADD COLUMN IF NOT EXISTS merged_from bigint;
+ALTER TABLE movie_revisions DROP CONSTRAINT IF EXISTS movie_revisions_movie_id_version_key;
+CREATE UNIQUE INDEX IF NOT EXISTS movie_revisions_movie_id_version_idx ON movie_revisions (movie_id, coalesce(merged_from, 0), version);
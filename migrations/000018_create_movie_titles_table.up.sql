@@ -0,0 +1,14 @@
+-- alternative titles of a movie, one per language tag ("es", "pt-BR", ...). movies.title stays the original title
+CREATE TABLE IF NOT EXISTS movie_titles (
+    id bigint PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    language text NOT NULL,
+    title text NOT NULL,
+    -- the text search config of the language, e.g. 'spanish' for "es" and "es-MX"
+    search_config regconfig NOT NULL DEFAULT 'simple',
+    search_vector tsvector GENERATED ALWAYS AS (to_tsvector(search_config, title)) STORED,
+    UNIQUE (movie_id, language)
+);
+
+CREATE INDEX IF NOT EXISTS movie_titles_search_vector_idx ON movie_titles USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS movie_titles_title_trgm_idx ON movie_titles USING GIN (title gin_trgm_ops);
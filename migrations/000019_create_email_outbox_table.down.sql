@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS email_outbox;
+DELETE FROM permissions WHERE code = 'outbox:admin';
@@ -0,0 +1,24 @@
+-- emails waiting to be sent, written in the same transaction as the change they come from
+CREATE TABLE IF NOT EXISTS email_outbox (
+    id bigint PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    -- the same email is only ever queued once
+    idempotency_key text NOT NULL UNIQUE,
+    recipient text NOT NULL,
+    template text NOT NULL,
+    data jsonb NOT NULL DEFAULT '{}',
+    status text NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'sent', 'dead')),
+    attempts integer NOT NULL DEFAULT 0,
+    next_attempt_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    -- set while a worker is sending the email, nobody else picks it up until then
+    locked_until timestamp with time zone,
+    last_error text,
+    sent_at timestamp with time zone
+);
+
+-- the worker only ever looks for pending emails that are due
+CREATE INDEX IF NOT EXISTS email_outbox_due_idx ON email_outbox (next_attempt_at) WHERE status = 'pending';
+
+INSERT INTO permissions (code)
+SELECT 'outbox:admin'
+WHERE NOT EXISTS (SELECT 1 FROM permissions WHERE code = 'outbox:admin');